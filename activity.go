@@ -28,6 +28,7 @@ import (
 	"net/http"
 	"os"
 	"regexp"
+	"sort"
 	"strings"
 	"sync"
 	"time"
@@ -79,6 +80,8 @@ func disableTLSValidation() {
 	honkTransport.TLSClientConfig = &tls.Config{
 		InsecureSkipVerify: true,
 	}
+	cardTransport.TLSClientConfig = honkTransport.TLSClientConfig
+	proxyTransport.TLSClientConfig = honkTransport.TLSClientConfig
 }
 
 func PostJunk(keyname string, key httpsig.PrivateKey, url string, j junk.Junk) error {
@@ -322,11 +325,15 @@ func savedonk(url string, name, desc, media string, localize bool) *Donk {
 				localize = false
 				data = []byte{}
 			}
-		} else if media == "video/mp4" {
-			if len(data) > 10000000 {
-				ilog.Printf("not saving large mp4")
+		} else if strings.HasPrefix(media, "audio") || strings.HasPrefix(media, "video") {
+			av := avsniff(data)
+			if av == "" || len(data) > avformats[av].limit {
+				ilog.Printf("not saving large or unknown media: %s", media)
 				localize = false
 				data = []byte{}
+			} else {
+				media = av
+				avmeta(data, media, &meta)
 			}
 		} else if len(data) > 100000 {
 			ilog.Printf("not saving large attachment")
@@ -414,6 +421,8 @@ func saveActivityPubActivity(x *ActivityPubActivity) {
 	go handles(x.Honker)
 	go handles(x.Oonker)
 	savehonk(x)
+	countfilters(x)
+	go cardify(x)
 }
 
 type Box struct {
@@ -568,6 +577,27 @@ func handleManyJunkTypes(obj junk.Junk, key string) []interface{} {
 	return arr
 }
 
+// whichever language matches the content, or the first one listed
+func contentlanguage(obj junk.Junk) string {
+	cm, ok := obj.GetMap("contentMap")
+	if !ok {
+		return ""
+	}
+	content, _ := obj.GetString("content")
+	var langs []string
+	for lang, c := range cm {
+		if s, _ := c.(string); s == content {
+			return lang
+		}
+		langs = append(langs, lang)
+	}
+	if len(langs) == 0 {
+		return ""
+	}
+	sort.Strings(langs)
+	return langs[0]
+}
+
 func firstofmany(obj junk.Junk, key string) string {
 	if val, _ := obj.GetString(key); val != "" {
 		return val
@@ -763,6 +793,13 @@ func xonksaver2(user *WhatAbout, item junk.Junk, origin string, myown bool) *Act
 			} else {
 				xid, _ = item.GetString("object")
 			}
+			if !isUpdate && ourhonk(user, xid) {
+				bonker, _ = item.GetString("actor")
+				if originate(bonker) == origin {
+					notify(user, noticeBoost, bonker, xid, "")
+				}
+				return nil
+			}
 			if !isUpdate && !needbonkid(user, xid) {
 				return nil
 			}
@@ -936,6 +973,7 @@ func xonksaver2(user *WhatAbout, item junk.Junk, origin string, myown bool) *Act
 		xonk.Public = loudandproud(xonk.Audience)
 
 		var mentions []Mention
+		var quoteof string
 		if obj != nil {
 			ot := firstofmany(obj, "type")
 			url, _ = obj.GetString("url")
@@ -977,6 +1015,11 @@ func xonksaver2(user *WhatAbout, item junk.Junk, origin string, myown bool) *Act
 				content += fmt.Sprintf(`<p><a href="%s">%s</a>`, url, url)
 				url = xid
 			}
+			for _, prop := range []string{"quoteUrl", "quoteUri", "_misskey_quote"} {
+				if quoteof, _ = obj.GetString(prop); quoteof != "" {
+					break
+				}
+			}
 			if user.Options.InlineQuotes {
 				qurl, _ := obj.GetString("quoteUrl")
 				content = qutify(user, qurl, content)
@@ -1027,6 +1070,8 @@ func xonksaver2(user *WhatAbout, item junk.Junk, origin string, myown bool) *Act
 
 			xonk.Noise = content
 			xonk.Precis = precis
+			xonk.Language = contentlanguage(obj)
+			prepfilters(&xonk)
 			if rejectxonk(&xonk) {
 				dlog.Printf("fast reject: %s", xid)
 				return nil
@@ -1069,11 +1114,12 @@ func xonksaver2(user *WhatAbout, item junk.Junk, origin string, myown bool) *Act
 					desc = name
 				}
 				localize := false
-				if at == "Document" || at == "Image" || at == "Video" {
+				if at == "Document" || at == "Image" || at == "Video" || at == "Audio" {
 					mt = strings.ToLower(mt)
 					dlog.Printf("attachment: %s %s", mt, u)
 					if mt == "text/plain" || mt == "application/pdf" ||
-						strings.HasPrefix(mt, "image") || mt == "video/mp4" {
+						strings.HasPrefix(mt, "image") || strings.HasPrefix(mt, "audio") ||
+						strings.HasPrefix(mt, "video") {
 						if numatts > 4 {
 							ilog.Printf("excessive attachment: %s", at)
 						} else {
@@ -1267,20 +1313,18 @@ func xonksaver2(user *WhatAbout, item junk.Junk, origin string, myown bool) *Act
 		if what == "chonk" {
 			// undo damage above
 			xonk.Noise = strings.TrimPrefix(xonk.Noise, "<p>")
-			target := firstofmany(obj, "to")
-			if target == user.URL {
-				target = xonk.Honker
-			}
+			// grouped by everyone involved, less us
+			target := chattarget(user, newphone([]string{xonk.Honker}, obj))
 			enc, _ := obj.GetString(chatKeyProp)
+			var newkey string
 			if enc != "" {
-				if pubkey, ok := getchatkey(xonk.Honker); ok {
-					dec, err := decryptString(xonk.Noise, user.ChatSecKey, pubkey)
-					if err != nil {
-						ilog.Printf("failed to decrypt chonk")
-					} else {
-						dlog.Printf("successful decrypt from %s", xonk.Honker)
-						xonk.Noise = dec
-					}
+				dec, keyprint, err := decryptchonk(user, xonk.Honker, xonk.Noise, enc)
+				if err != nil {
+					ilog.Printf("failed to decrypt chonk: %s", err)
+				} else {
+					dlog.Printf("successful decrypt from %s", xonk.Honker)
+					xonk.Noise = dec
+					newkey = keyprint
 				}
 			}
 			ch := Chonk{
@@ -1291,6 +1335,7 @@ func xonksaver2(user *WhatAbout, item junk.Junk, origin string, myown bool) *Act
 				Date:   xonk.Date,
 				Noise:  xonk.Noise,
 				Format: xonk.Format,
+				NewKey: newkey,
 				Donks:  xonk.Donks,
 			}
 			savechonk(&ch)
@@ -1330,7 +1375,13 @@ func xonksaver2(user *WhatAbout, item junk.Junk, origin string, myown bool) *Act
 				currenttid = convoy
 			}
 			xonk.Convoy = convoy
+			if !myown {
+				spamcheck(user, &xonk)
+			}
 			saveActivityPubActivity(&xonk)
+			if !myown && goingup == 0 {
+				honknotices(user, &xonk, quoteof)
+			}
 		}
 		if goingup == 0 {
 			for _, replid := range replies {
@@ -1358,11 +1409,15 @@ func dumpactivity(item junk.Junk) {
 }
 
 func rubadubdub(user *WhatAbout, req junk.Junk) {
+	answerdub(user, req, "Accept")
+}
+
+func answerdub(user *WhatAbout, req junk.Junk, answer string) {
 	actor, _ := req.GetString("actor")
 	j := junk.New()
 	j["@context"] = itiswhatitis
 	j["id"] = user.URL + "/dub/" + make18CharRandomString()
-	j["type"] = "Accept"
+	j["type"] = answer
 	j["actor"] = user.URL
 	j["to"] = actor
 	j["published"] = time.Now().UTC().Format(time.RFC3339)
@@ -1418,6 +1473,16 @@ func activatedonks(donks []*Donk) []junk.Junk {
 		jd["name"] = d.Name
 		jd["summary"] = html.EscapeString(d.Desc)
 		jd["type"] = "Document"
+		if isav(d.Media) {
+			jd["type"] = avkind(d.Media)
+			if d.Meta.Width > 0 {
+				jd["width"] = d.Meta.Width
+				jd["height"] = d.Meta.Height
+			}
+			if d.Meta.Duration > 0 {
+				jd["duration"] = fmt.Sprintf("PT%gS", time.Duration(d.Meta.Duration).Seconds())
+			}
+		}
 		jd["url"] = d.URL
 		atts = append(atts, jd)
 	}
@@ -1697,7 +1762,7 @@ func boxuprcpts(user *WhatAbout, addresses []string, useshared bool) map[string]
 
 func chonkifymsg(user *WhatAbout, rcpt string, ch *Chonk) []byte {
 	dt := ch.Date.Format(time.RFC3339)
-	aud := []string{ch.Target}
+	aud := strings.Fields(ch.Target)
 
 	jo := junk.New()
 	jo["id"] = ch.XID
@@ -1707,7 +1772,7 @@ func chonkifymsg(user *WhatAbout, rcpt string, ch *Chonk) []byte {
 	jo["to"] = aud
 	content := string(ch.HTML)
 	if user.ChatSecKey.key != nil {
-		if pubkey, ok := getchatkey(rcpt); ok {
+		if pubkey, ok := peerchatkey(user, rcpt); ok {
 			enc, err := encryptString(content, user.ChatSecKey, pubkey)
 			if err != nil {
 				ilog.Printf("failure encrypting chonk: %s", err)
@@ -1753,9 +1818,7 @@ func chonkifymsg(user *WhatAbout, rcpt string, ch *Chonk) []byte {
 }
 
 func sendchonk(user *WhatAbout, ch *Chonk) {
-	rcpts := make(map[string]bool)
-	rcpts[ch.Target] = true
-	for a := range rcpts {
+	for _, a := range strings.Fields(ch.Target) {
 		msg := chonkifymsg(user, a, ch)
 		go deliverate(user.ID, a, msg)
 	}
@@ -1768,7 +1831,8 @@ func honkworldwide(user *WhatAbout, honk *ActivityPubActivity) {
 
 	aud := honk.Audience
 
-	if honk.Public || isAdvancedPrivateHonkActually(user, honk) {
+	// circle honks go to their audience and nobody else
+	if honk.Circle == "" && (honk.Public || isAdvancedPrivateHonkActually(user, honk)) {
 		for _, h := range getdubs(user.ID) {
 			if h.XID == user.URL {
 				continue
@@ -1781,10 +1845,21 @@ func honkworldwide(user *WhatAbout, honk *ActivityPubActivity) {
 			}
 		}
 	}
-	rcpts := boxuprcpts(user, aud, honk.Public)
+	rcpts := boxuprcpts(user, aud, honk.Public && honk.Circle == "")
+	limit := quotalimit(user.Options.QuotaDeliveries, live().QuotaDeliveries)
 
 	go func() {
+		var boxes []string
 		for a := range rcpts {
+			boxes = append(boxes, a)
+		}
+		// over quota waits its turn in the doovers
+		times := deliveryslots.schedule(user.ID, limit, len(boxes))
+		for i, a := range boxes {
+			if !times[i].IsZero() {
+				postpone(user.ID, a, msg, times[i])
+				continue
+			}
 			time.Sleep(time.Millisecond * 16)
 			go deliverate(user.ID, a, msg)
 		}
@@ -1844,7 +1919,7 @@ func junkuser(user *WhatAbout, private bool) junk.Junk {
 	j["outbox"] = user.URL + "/outbox"
 	j["name"] = user.Display
 	j["preferredUsername"] = user.Name
-	j["manuallyApprovesFollowers"] = false // Have to set this or some clients assume you are private
+	j["manuallyApprovesFollowers"] = user.Options.ManualFollows // Have to set this or some clients assume you are private
 	j["discoverable"] = true
 	j["indexable"] = true
 	j["memorial"] = false // Only alive users please
@@ -2037,9 +2112,10 @@ func allinjest(origin string, obj junk.Junk) {
 	}
 	ingestboxes(origin, obj)
 	ingesthandle(origin, obj)
+	ingestactorinfo(origin, obj)
 	chatkey, ok := obj.GetString(chatKeyProp)
 	if ok {
-		savexonker(ident, chatkey, chatKeyProp)
+		ingestchatkey(ident, chatkey)
 	}
 }
 
@@ -2130,6 +2206,110 @@ func ingesthandle(origin string, obj junk.Junk) {
 	}
 }
 
+// what an actor says about itself, for filters that care
+type ActorInfo struct {
+	Published    time.Time `json:",omitempty"`
+	Followers    int64
+	FollowersURL string `json:",omitempty"`
+}
+
+const actorInfoProp = "actorinfo"
+
+var actorinfos = gencache.New(gencache.Options[string, *ActorInfo]{Fill: func(xid string) (*ActorInfo, bool) {
+	data := getxonker(xid, actorInfoProp)
+	if data == "" {
+		return nil, true
+	}
+	info := new(ActorInfo)
+	err := decodeJson(data, info)
+	if err != nil {
+		elog.Printf("error decoding actor info: %s", err)
+		return nil, true
+	}
+	return info, true
+}, Invalidator: &xonkInvalidator})
+
+func getactorinfo(xid string) *ActorInfo {
+	info, _ := actorinfos.Get(xid)
+	return info
+}
+
+func saveactorinfo(xid string, info *ActorInfo) {
+	j, err := encodeJson(info)
+	if err != nil {
+		elog.Printf("error encoding actor info: %s", err)
+		return
+	}
+	when := time.Now().Add(time.Minute).UTC().Format(dbtimeformat)
+	stmtDeleteXonker.Exec(xid, actorInfoProp, when)
+	savexonker(xid, j, actorInfoProp)
+	xonkInvalidator.Clear(xid)
+}
+
+func ingestactorinfo(origin string, obj junk.Junk) {
+	xid, _ := obj.GetString("id")
+	if xid == "" || originate(xid) != origin {
+		return
+	}
+	switch firstofmany(obj, "type") {
+	case "Person", "Service", "Application", "Group", "Organization":
+	default:
+		return
+	}
+	info := &ActorInfo{Followers: -1}
+	if dt, ok := obj.GetString("published"); ok {
+		info.Published, _ = time.Parse(time.RFC3339, dt)
+	}
+	if fobj, ok := obj.GetMap("followers"); ok {
+		if n, ok := fobj.GetNumber("totalItems"); ok {
+			info.Followers = int64(n)
+		}
+	} else {
+		info.FollowersURL, _ = obj.GetString("followers")
+	}
+	if old := getactorinfo(xid); old != nil && info.Followers < 0 {
+		info.Followers = old.Followers
+		if old.FollowersURL == "" {
+			info.FollowersURL = ""
+		}
+	}
+	saveactorinfo(xid, info)
+}
+
+// only asks the webs for what isn't known yet
+func fetchactorinfo(xid string) *ActorInfo {
+	if !strings.HasPrefix(xid, "https://") {
+		return nil
+	}
+	info := getactorinfo(xid)
+	if info == nil {
+		j, err := GetJunk(firstUserUID, xid)
+		if err != nil {
+			ilog.Printf("error getting actor %s: %s", xid, err)
+			return nil
+		}
+		allinjest(originate(xid), j)
+		info = getactorinfo(xid)
+		if info == nil {
+			return nil
+		}
+	}
+	if info.Followers < 0 && info.FollowersURL != "" {
+		more := *info
+		j, err := GetJunk(firstUserUID, more.FollowersURL)
+		if err == nil {
+			if n, ok := j.GetNumber("totalItems"); ok {
+				more.Followers = int64(n)
+			}
+		}
+		// hidden or broken, don't keep asking
+		more.FollowersURL = ""
+		saveactorinfo(xid, &more)
+		info = &more
+	}
+	return info
+}
+
 func updateMe(username string) {
 	user, _ := somenamedusers.Get(username)
 	dt := time.Now().UTC().Format(time.RFC3339)
@@ -2166,30 +2346,76 @@ func followme(user *WhatAbout, who string, name string, j junk.Junk) {
 
 	ilog.Printf("updating honker follow: %s %s", who, folxid)
 
-	var x string
+	// locked accounts keep new followers waiting
+	newflavor := "dub"
+	if user.Options.ManualFollows {
+		newflavor = "predub"
+	}
+	var flavor string
 	db := opendatabase()
-	row := db.QueryRow("select xid from honkers where name = ? and xid = ? and userid = ? and flavor in ('dub', 'undub')", name, who, user.ID)
-	err := row.Scan(&x)
+	row := db.QueryRow("select flavor from honkers where name = ? and xid = ? and userid = ? and flavor in ('dub', 'undub', 'predub')", name, who, user.ID)
+	err := row.Scan(&flavor)
 	if err != sql.ErrNoRows {
 		ilog.Printf("duplicate follow request: %s", who)
-		_, err = stmtUpdateFlavor.Exec("dub", folxid, user.ID, name, who, "undub")
-		if err != nil {
-			elog.Printf("error updating honker: %s", err)
+		if flavor == "dub" {
+			newflavor = "dub"
+		} else {
+			_, err = stmtUpdateFlavor.Exec(newflavor, folxid, user.ID, name, who, flavor)
+			if err != nil {
+				elog.Printf("error updating honker: %s", err)
+			}
 		}
 	} else {
-		stmtSaveDub.Exec(user.ID, name, who, "dub", folxid)
+		stmtSaveDub.Exec(user.ID, name, who, newflavor, folxid)
+	}
+	if newflavor == "predub" {
+		notify(user, noticeRequest, who, folxid, "")
+		return
 	}
 	calculateFollowersForMetrics()
+	notify(user, noticeFollow, who, folxid, "")
 	go rubadubdub(user, j)
 }
 
+// the original follow isn't kept, but its id is enough
+func approvedub(user *WhatAbout, honkerid int64, approve bool) error {
+	var who, folxid string
+	db := opendatabase()
+	row := db.QueryRow("select xid, folxid from honkers where honkerid = ? and userid = ? and flavor = 'predub'", honkerid, user.ID)
+	err := row.Scan(&who, &folxid)
+	if err != nil {
+		return err
+	}
+	flavor := "undub"
+	if approve {
+		flavor = "dub"
+	}
+	_, err = db.Exec("update honkers set flavor = ? where honkerid = ?", flavor, honkerid)
+	if err != nil {
+		return err
+	}
+	ilog.Printf("%s follow request from %s: %s", user.Name, who, flavor)
+	req := junk.New()
+	req["id"] = folxid
+	req["type"] = "Follow"
+	req["actor"] = who
+	req["object"] = user.URL
+	if approve {
+		calculateFollowersForMetrics()
+		go answerdub(user, req, "Accept")
+	} else {
+		go answerdub(user, req, "Reject")
+	}
+	return nil
+}
+
 func unfollowme(user *WhatAbout, who string, name string, j junk.Junk) {
 	var folxid string
 	if who == "" {
 		folxid, _ = j.GetString("object")
 
 		db := opendatabase()
-		row := db.QueryRow("select xid, name from honkers where userid = ? and folxid = ? and flavor in ('dub', 'undub')", user.ID, folxid)
+		row := db.QueryRow("select xid, name from honkers where userid = ? and folxid = ? and flavor in ('dub', 'undub', 'predub')", user.ID, folxid)
 		err := row.Scan(&who, &name)
 		if err != nil {
 			if err != sql.ErrNoRows {
@@ -2200,10 +2426,12 @@ func unfollowme(user *WhatAbout, who string, name string, j junk.Junk) {
 	}
 
 	ilog.Printf("updating honker undo: %s %s", who, folxid)
-	_, err := stmtUpdateFlavor.Exec("undub", folxid, user.ID, name, who, "dub")
-	if err != nil {
-		elog.Printf("error updating honker: %s", err)
-		return
+	for _, flavor := range []string{"dub", "predub"} {
+		_, err := stmtUpdateFlavor.Exec("undub", folxid, user.ID, name, who, flavor)
+		if err != nil {
+			elog.Printf("error updating honker: %s", err)
+			return
+		}
 	}
 }
 
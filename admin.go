@@ -16,10 +16,13 @@
 package main
 
 import (
+	"net/http"
+	"strconv"
 	"strings"
 
 	"humungus.tedunangst.com/r/termvc"
 	"humungus.tedunangst.com/r/webs/log"
+	"humungus.tedunangst.com/r/webs/login"
 )
 
 func adminscreen() {
@@ -27,7 +30,7 @@ func adminscreen() {
 
 	var avatarColors string
 	getConfigValue("avatarcolors", &avatarColors)
-	loadLingo()
+	lc := live()
 
 	type adminfield struct {
 		name  string
@@ -40,17 +43,17 @@ func adminscreen() {
 		{
 			name:  "servermsg",
 			label: "server banner",
-			text:  string(serverMsg),
+			text:  string(lc.ServerMsg),
 		},
 		{
 			name:  "aboutmsg",
 			label: "about page message",
-			text:  string(aboutMsg),
+			text:  string(lc.AboutMsg),
 		},
 		{
 			name:  "loginmsg",
 			label: "login banner",
-			text:  string(loginMsg),
+			text:  string(lc.LoginMsg),
 		},
 		{
 			name:  "avatarcolors",
@@ -76,9 +79,9 @@ func adminscreen() {
 	{
 		var inputs []termvc.Element
 		var offset int
-		for _, l := range []string{"honked", "bonked", "honked back", "qonked", "evented"} {
+		for _, l := range lingos {
 			field := termvc.NewTextInput(l, &offset)
-			field.Set(relingo[l])
+			field.Set(lc.Lingo[l])
 			inputs = append(inputs, field)
 			messages = append(messages, &adminfield{
 				name: "lingo-" + strings.ReplaceAll(l, " ", ""),
@@ -112,3 +115,132 @@ func adminscreen() {
 	go termvc.Catch(nil)
 	app.Loop()
 }
+
+type configfield struct {
+	Key     string
+	Label   string
+	Value   string
+	Long    bool
+	Number  bool
+	Restart bool
+}
+
+func getconfigfields() []*configfield {
+	fields := []*configfield{
+		{Key: "servername", Label: "server name", Restart: true},
+		{Key: "masqname", Label: "masquerade name", Restart: true},
+		{Key: "usersep", Label: "user path", Restart: true},
+		{Key: "honksep", Label: "honk path", Restart: true},
+		{Key: "usefilestore", Label: "store attachments as files (0 or 1)", Number: true, Restart: true},
+		{Key: "devel", Label: "devel mode (0 or 1)", Number: true, Restart: true},
+		{Key: "servermsg", Label: "server banner", Long: true},
+		{Key: "aboutmsg", Label: "about page message", Long: true},
+		{Key: "loginmsg", Label: "login banner", Long: true},
+		{Key: "avatarcolors", Label: "avatar colors (4 RGBA hex numbers)"},
+		{Key: "fasttimeout", Label: "fast timeout (seconds)", Number: true, Restart: true},
+		{Key: "slowtimeout", Label: "slow timeout (seconds)", Number: true, Restart: true},
+		{Key: "cardtimeout", Label: "card timeout (seconds)", Number: true, Restart: true},
+		{Key: "carddenylist", Label: "card deny list"},
+		{Key: "trustedproxies", Label: "trusted proxies"},
+		{Key: "honkwindow", Label: "honk window (days)", Number: true, Restart: true},
+		{Key: "collectforwards", Label: "collect forwards (0 or 1)", Number: true, Restart: true},
+		{Key: "quotamegabytes", Label: "attachment megabytes per user", Number: true},
+		{Key: "quotahonks", Label: "honks per hour per user", Number: true},
+		{Key: "quotadeliveries", Label: "deliveries per hour per user", Number: true},
+	}
+	for _, l := range lingos {
+		fields = append(fields, &configfield{
+			Key:   "lingo-" + strings.ReplaceAll(l, " ", ""),
+			Label: "lingo for " + l,
+		})
+	}
+	for _, f := range fields {
+		getConfigValue(f.Key, &f.Value)
+	}
+	return fields
+}
+
+// some settings are only read at startup
+var restartneeded bool
+
+func getqueuedepth() int64 {
+	var depth int64
+	row := opendatabase().QueryRow("select count(*) from doovers")
+	err := row.Scan(&depth)
+	if err != nil {
+		elog.Printf("error counting doovers: %s", err)
+	}
+	return depth
+}
+
+func adminpage(w http.ResponseWriter, r *http.Request) {
+	u := login.GetUserInfo(r)
+	user, _ := getUserBio(u.Username)
+	if !isadmin(user) {
+		http.Error(w, "admins only", http.StatusForbidden)
+		return
+	}
+	templinfo := getInfo(r)
+	templinfo["AdminCSRF"] = login.GetCSRF("admin", r)
+	templinfo["Sensors"] = getSensors()
+	templinfo["QueueDepth"] = getqueuedepth()
+	templinfo["Config"] = getconfigfields()
+	templinfo["NeedRestart"] = restartneeded
+	templinfo["Users"] = getallusers()
+	templinfo["Invites"] = getinvites()
+	err := readviews.Execute(w, "admin.html", templinfo)
+	if err != nil {
+		elog.Print(err)
+	}
+}
+
+func adminconfig(w http.ResponseWriter, r *http.Request) {
+	u := login.GetUserInfo(r)
+	admin, _ := getUserBio(u.Username)
+	if !isadmin(admin) {
+		http.Error(w, "admins only", http.StatusForbidden)
+		return
+	}
+	fields := getconfigfields()
+	// check everything before saving anything
+	for _, f := range fields {
+		val := strings.TrimSpace(r.FormValue("config-" + f.Key))
+		if val == "" && f.Key == "servername" {
+			http.Error(w, "server name can't be empty", http.StatusBadRequest)
+			return
+		}
+		if _, err := strconv.Atoi(val); f.Number && val != "" && err != nil {
+			http.Error(w, "not a number: "+f.Label, http.StatusBadRequest)
+			return
+		}
+	}
+	db := opendatabase()
+	for _, f := range fields {
+		val := strings.ReplaceAll(r.FormValue("config-"+f.Key), "\r", "")
+		if !f.Long {
+			val = strings.TrimSpace(val)
+		}
+		if val == f.Value {
+			continue
+		}
+		ilog.Printf("%s changed config %s", admin.Name, f.Key)
+		var err error
+		if val == "" {
+			// back to the default
+			_, err = db.Exec("delete from config where key = ?", f.Key)
+		} else if f.Number {
+			n, _ := strconv.Atoi(val)
+			err = setconfig(f.Key, n)
+		} else {
+			err = setconfig(f.Key, val)
+		}
+		if err != nil {
+			elog.Printf("error saving config %s: %s", f.Key, err)
+		}
+		if f.Restart {
+			restartneeded = true
+		}
+	}
+	reloadconfig()
+	http.Redirect(w, r, "/admin", http.StatusSeeOther)
+}
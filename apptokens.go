@@ -0,0 +1,456 @@
+//
+// Copyright (c) 2024 Ted Unangst <tedu@tedunangst.com>
+//
+// Permission to use, copy, modify, and distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha512"
+	"database/sql"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"humungus.tedunangst.com/r/webs/gencache"
+	"humungus.tedunangst.com/r/webs/login"
+)
+
+// app tokens live in the auth table next to login sessions.
+// sessions have null scopes and can do anything.
+type AuthEntry struct {
+	ID       int64
+	App      bool
+	Current  bool
+	Name     string
+	Scopes   []string
+	Created  time.Time
+	Expiry   time.Time
+	LastUsed time.Time
+	Deadline time.Time
+	IP       string
+	Agent    string
+}
+
+var appScopes = []string{"read", "post", "zonk", "chat", "follow", "raw"}
+
+// what each api action needs
+var apiScopes = map[string]string{
+	"honk":         "post",
+	"donk":         "post",
+	"zonkit":       "zonk",
+	"gethonks":     "read",
+	"sendactivity": "raw",
+	"gethonkers":   "follow",
+	"savehonker":   "follow",
+	"getchatter":   "chat",
+	"getnotices":   "read",
+	"readnotices":  "zonk",
+}
+
+type authinfo struct {
+	ID       int64
+	UserID   UserID
+	App      bool
+	Scopes   []string
+	Deadline time.Time
+	IP       string
+	Agent    string
+}
+
+const authlen = 32
+
+func authhash(auth string) string {
+	hasher := sha512.New512_256()
+	hasher.Write([]byte(auth))
+	return fmt.Sprintf("%x", hasher.Sum(nil))[0:authlen]
+}
+
+var authcache = gencache.New(gencache.Options[string, *authinfo]{Fill: func(hash string) (*authinfo, bool) {
+	now := time.Now().UTC()
+	row := stmtGetAuth.QueryRow(hash, now.Format(dbtimeformat))
+	info := new(authinfo)
+	var scopes sql.NullString
+	var deadline string
+	err := row.Scan(&info.ID, &info.UserID, &scopes, &deadline, &info.IP, &info.Agent)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			elog.Printf("error scanning auth: %s", err)
+			return nil, false
+		}
+		return nil, true
+	}
+	if scopes.Valid {
+		info.App = true
+		info.Scopes = strings.Fields(scopes.String)
+		info.Deadline, _ = time.Parse(dbtimeformat, deadline)
+	}
+	// only as fresh as the cache
+	stamp := now.Format(dbtimeformat)
+	_, err = stmtAuthUsed.Exec(stamp, stamp, info.ID)
+	if err != nil {
+		elog.Printf("error updating auth: %s", err)
+	}
+	return info, true
+}, Duration: 1 * time.Minute})
+
+func getauthinfo(auth string) *authinfo {
+	if auth == "" {
+		return nil
+	}
+	info, _ := authcache.Get(authhash(auth))
+	if info != nil && info.App && !info.Deadline.IsZero() && info.Deadline.Before(time.Now()) {
+		return nil
+	}
+	return info
+}
+
+// addresses or networks, space separated
+func parseproxies(s string) []*net.IPNet {
+	var nets []*net.IPNet
+	for _, f := range strings.Fields(s) {
+		if !strings.Contains(f, "/") {
+			if ip := net.ParseIP(f); ip != nil && ip.To4() != nil {
+				f += "/32"
+			} else {
+				f += "/128"
+			}
+		}
+		_, n, err := net.ParseCIDR(f)
+		if err != nil {
+			elog.Printf("bad trusted proxy: %s", err)
+			continue
+		}
+		nets = append(nets, n)
+	}
+	return nets
+}
+
+func trustedproxy(ip net.IP) bool {
+	for _, n := range live().TrustedProxies {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// forwarded for is only believed when our proxies send it,
+// and then only the last hop they didn't add themselves.
+func requestaddr(r *http.Request) string {
+	addr, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		addr = r.RemoteAddr
+	}
+	if ip := net.ParseIP(addr); ip == nil || !trustedproxy(ip) {
+		return addr
+	}
+	hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		ip := net.ParseIP(hop)
+		if ip == nil {
+			break
+		}
+		addr = ip.String()
+		if !trustedproxy(ip) {
+			break
+		}
+	}
+	return addr
+}
+
+var authseenmtx sync.Mutex
+
+// remember where a session or token was last seen from
+func seenauth(info *authinfo, r *http.Request) {
+	ip := requestaddr(r)
+	agent := r.UserAgent()
+	if len(agent) > 256 {
+		agent = agent[:256]
+	}
+	authseenmtx.Lock()
+	if info.IP == ip && info.Agent == agent {
+		authseenmtx.Unlock()
+		return
+	}
+	info.IP = ip
+	info.Agent = agent
+	authseenmtx.Unlock()
+	_, err := stmtAuthSeen.Exec(ip, agent, info.ID)
+	if err != nil {
+		elog.Printf("error updating auth: %s", err)
+	}
+}
+
+func requesttoken(r *http.Request) string {
+	token := r.FormValue("token")
+	if token == "" {
+		token = r.Header.Get("Authorization")
+	}
+	if strings.HasPrefix(strings.ToLower(token), "bearer ") {
+		token = token[7:]
+	}
+	return token
+}
+
+// check that the token for this request may be used for scope
+func tokenallows(r *http.Request, scope string) bool {
+	info := getauthinfo(requesttoken(r))
+	if info == nil {
+		return false
+	}
+	seenauth(info, r)
+	if !info.App {
+		return true
+	}
+	for _, s := range info.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+func scopeRequired(scope string, handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !tokenallows(r, scope) {
+			http.Error(w, "token not allowed to "+scope, http.StatusForbidden)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
+
+// app tokens are not cookies, and deleted sessions stay deleted
+func authguard(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if u := login.GetUserInfo(r); u != nil {
+			user, _ := getUserBio(u.Username)
+			gone := user == nil || suspended(user)
+			cookie, err := r.Cookie("auth")
+			if err == nil {
+				info := getauthinfo(cookie.Value)
+				if info == nil || info.App || gone {
+					http.SetCookie(w, &http.Cookie{
+						Name:     "auth",
+						Value:    "",
+						MaxAge:   -1,
+						Secure:   !develMode,
+						HttpOnly: true,
+					})
+					http.Redirect(w, r, "/", http.StatusSeeOther)
+					return
+				}
+				seenauth(info, r)
+			} else if gone {
+				http.Error(w, "account suspended", http.StatusForbidden)
+				return
+			}
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
+
+func makeapptoken(userid UserID, name string, scopes []string, deadline time.Time) (string, error) {
+	hasher := sha512.New512_256()
+	io.CopyN(hasher, rand.Reader, 32)
+	token := fmt.Sprintf("%x", hasher.Sum(nil))[0:authlen]
+	now := time.Now().UTC()
+	var until string
+	if !deadline.IsZero() {
+		until = deadline.UTC().Format(dbtimeformat)
+	}
+	// login renews expiry as it pleases, the deadline is ours
+	expiry := now.Add(100 * 365 * 24 * time.Hour).Format(dbtimeformat)
+	_, err := stmtSaveAppToken.Exec(userid, authhash(token), expiry, name,
+		strings.Join(scopes, " "), now.Format(dbtimeformat), until)
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+func getauths(userid UserID) []*AuthEntry {
+	rows, err := stmtGetAuths.Query(userid)
+	if err != nil {
+		elog.Printf("error querying auth: %s", err)
+		return nil
+	}
+	defer rows.Close()
+	var auths []*AuthEntry
+	for rows.Next() {
+		a := new(AuthEntry)
+		var scopes sql.NullString
+		var created, expiry, lastused, deadline string
+		err = rows.Scan(&a.ID, &a.Name, &scopes, &created, &expiry, &lastused, &deadline, &a.IP, &a.Agent)
+		if err != nil {
+			elog.Printf("error scanning auth: %s", err)
+			continue
+		}
+		if scopes.Valid {
+			a.App = true
+			a.Scopes = strings.Fields(scopes.String)
+		}
+		a.Created, _ = time.Parse(dbtimeformat, created)
+		a.Expiry, _ = time.Parse(dbtimeformat, expiry)
+		a.LastUsed, _ = time.Parse(dbtimeformat, lastused)
+		a.Deadline, _ = time.Parse(dbtimeformat, deadline)
+		auths = append(auths, a)
+	}
+	return auths
+}
+
+func revokeauth(userid UserID, authid int64) error {
+	row := stmtGetAuthHash.QueryRow(authid, userid)
+	var hash string
+	err := row.Scan(&hash)
+	if err != nil {
+		return err
+	}
+	_, err = stmtDeleteOneAuth.Exec(authid, userid)
+	authcache.Clear(hash)
+	return err
+}
+
+// everything except the session making the request
+func revokeothers(userid UserID, keep int64) error {
+	_, err := stmtDeleteOtherAuth.Exec(userid, keep)
+	authcache.Flush()
+	return err
+}
+
+func currentauth(r *http.Request) int64 {
+	cookie, err := r.Cookie("auth")
+	if err != nil {
+		return 0
+	}
+	if info := getauthinfo(cookie.Value); info != nil {
+		return info.ID
+	}
+	return 0
+}
+
+func revokehandler(w http.ResponseWriter, r *http.Request) {
+	u := login.GetUserInfo(r)
+	userid := UserID(u.UserID)
+	var err error
+	if r.FormValue("everywhere") != "" {
+		err = revokeothers(userid, currentauth(r))
+	} else {
+		authid, _ := strconv.ParseInt(r.FormValue("authid"), 10, 0)
+		err = revokeauth(userid, authid)
+	}
+	if err != nil {
+		elog.Printf("error revoking auth: %s", err)
+	}
+	http.Redirect(w, r, "/account", http.StatusSeeOther)
+}
+
+func apptokenhandler(w http.ResponseWriter, r *http.Request) {
+	u := login.GetUserInfo(r)
+	userid := UserID(u.UserID)
+	name := strings.TrimSpace(r.FormValue("name"))
+	if name == "" {
+		http.Error(w, "tokens need a name", http.StatusBadRequest)
+		return
+	}
+	var scopes []string
+	for _, s := range appScopes {
+		if r.FormValue("scope-"+s) == "yes" {
+			scopes = append(scopes, s)
+		}
+	}
+	if len(scopes) == 0 {
+		http.Error(w, "tokens need a scope", http.StatusBadRequest)
+		return
+	}
+	var deadline time.Time
+	if dur := parseDuration(r.FormValue("duration")); dur > 0 {
+		deadline = time.Now().Add(dur)
+	}
+	token, err := makeapptoken(userid, name, scopes, deadline)
+	if err != nil {
+		elog.Printf("error saving token: %s", err)
+		http.Error(w, "error saving token", http.StatusInternalServerError)
+		return
+	}
+	ilog.Printf("new app token %s for %s", name, u.Username)
+	showaccount(w, r, map[string]interface{}{"NewToken": token})
+}
+
+func logout(w http.ResponseWriter, r *http.Request) {
+	// keep app tokens, unlike login.LogoutFunc
+	if u, ok := login.CheckCookie(r); ok && login.CheckCSRF("logout", r) {
+		_, err := stmtDeleteSessions.Exec(u.UserID)
+		if err != nil {
+			elog.Printf("error deleting sessions: %s", err)
+		}
+		authcache.Flush()
+		http.SetCookie(w, &http.Cookie{
+			Name:     "auth",
+			Value:    "",
+			MaxAge:   -1,
+			Secure:   !develMode,
+			HttpOnly: true,
+		})
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+	login.LogoutFunc(w, r)
+}
+
+func listsessions(username string) {
+	user, err := getUserBio(username)
+	if err != nil {
+		errx("user %s not found", username)
+	}
+	for _, a := range getauths(user.ID) {
+		what := "session"
+		if a.App {
+			what = "token " + a.Name
+		}
+		seen := "never"
+		if !a.LastUsed.IsZero() {
+			seen = a.LastUsed.Format(dbtimeformat)
+		}
+		fmt.Printf("%d\t%s\t%s\t%s\t%s\n", a.ID, what, seen, a.IP, a.Agent)
+	}
+}
+
+func logoutuser(username string, args []string) {
+	user, err := getUserBio(username)
+	if err != nil {
+		errx("user %s not found", username)
+	}
+	if len(args) == 0 {
+		_, err = stmtDeleteOtherAuth.Exec(user.ID, 0)
+		if err != nil {
+			errx("error deleting auth: %s", err)
+		}
+		return
+	}
+	for _, arg := range args {
+		authid, _ := strconv.ParseInt(arg, 10, 0)
+		err = revokeauth(user.ID, authid)
+		if err != nil {
+			errx("error revoking %s: %s", arg, err)
+		}
+	}
+}
@@ -38,13 +38,14 @@ var avatarcolors = [4][4]byte{
 	{96, 0, 192, 255},
 }
 
-func loadAvatarColors() {
-	var colors string
-	getConfigValue("avatarcolors", &colors)
-	if colors == "" {
-		return
+func loadAvatarColors() [4][4]byte {
+	colors := avatarcolors
+	var conf string
+	getConfigValue("avatarcolors", &conf)
+	if conf == "" {
+		return colors
 	}
-	r := bufio.NewReader(strings.NewReader(colors))
+	r := bufio.NewReader(strings.NewReader(conf))
 	for i := 0; i < 4; i++ {
 		l, _ := r.ReadString(' ')
 		for l == " " {
@@ -59,14 +60,16 @@ func loadAvatarColors() {
 			elog.Printf("error reading avatar color %d: %s", i, err)
 			continue
 		}
-		avatarcolors[i][0] = byte(c >> 24 & 0xff)
-		avatarcolors[i][1] = byte(c >> 16 & 0xff)
-		avatarcolors[i][2] = byte(c >> 8 & 0xff)
-		avatarcolors[i][3] = byte(c >> 0 & 0xff)
+		colors[i][0] = byte(c >> 24 & 0xff)
+		colors[i][1] = byte(c >> 16 & 0xff)
+		colors[i][2] = byte(c >> 8 & 0xff)
+		colors[i][3] = byte(c >> 0 & 0xff)
 	}
+	return colors
 }
 
 func genAvatar(name string) []byte {
+	avatarcolors := live().AvatarColors
 	h := sha512.New()
 	h.Write([]byte(name))
 	s := h.Sum(nil)
@@ -68,12 +68,12 @@ func backupDatabase(dirname string) {
 		elog.Fatal(err)
 	}
 	orig := opendatabase()
-	rows := queryDB(orig, "select userid, username, hash, displayname, about, pubkey, seckey, options from users")
+	rows := queryDB(orig, "select userid, username, hash, displayname, about, pubkey, seckey, options, userflags from users")
 	for rows.Next() {
-		var userid int64
+		var userid, flags int64
 		var username, hash, displayname, about, pubkey, seckey, options string
-		scanDBRow(rows, &userid, &username, &hash, &displayname, &about, &pubkey, &seckey, &options)
-		sqlMustQuery(tx, "insert into users (userid, username, hash, displayname, about, pubkey, seckey, options) values (?, ?, ?, ?, ?, ?, ?, ?)", userid, username, hash, displayname, about, pubkey, seckey, options)
+		scanDBRow(rows, &userid, &username, &hash, &displayname, &about, &pubkey, &seckey, &options, &flags)
+		sqlMustQuery(tx, "insert into users (userid, username, hash, displayname, about, pubkey, seckey, options, userflags) values (?, ?, ?, ?, ?, ?, ?, ?, ?)", userid, username, hash, displayname, about, pubkey, seckey, options, flags)
 	}
 	rows.Close()
 
@@ -138,13 +138,13 @@ func backupDatabase(dirname string) {
 		rows.Close()
 	}
 	chonkids := make(map[int64]bool)
-	rows = queryDB(orig, "select chonkid, userid, xid, who, target, dt, noise, format from chonks")
+	rows = queryDB(orig, "select chonkid, userid, xid, who, target, dt, noise, format, newkey from chonks")
 	for rows.Next() {
 		var chonkid, userid int64
-		var xid, who, target, dt, noise, format string
-		scanDBRow(rows, &chonkid, &userid, &xid, &who, &target, &dt, &noise, &format)
+		var xid, who, target, dt, noise, format, newkey string
+		scanDBRow(rows, &chonkid, &userid, &xid, &who, &target, &dt, &noise, &format, &newkey)
 		chonkids[chonkid] = true
-		sqlMustQuery(tx, "insert into chonks (chonkid, userid, xid, who, target, dt, noise, format) values (?, ?, ?, ?, ?, ?, ?, ?)", chonkid, userid, xid, who, target, dt, noise, format)
+		sqlMustQuery(tx, "insert into chonks (chonkid, userid, xid, who, target, dt, noise, format, newkey) values (?, ?, ?, ?, ?, ?, ?, ?, ?)", chonkid, userid, xid, who, target, dt, noise, format, newkey)
 	}
 	rows.Close()
 	for c := range chonkids {
@@ -0,0 +1,329 @@
+//
+// Copyright (c) 2024 Ted Unangst <tedu@tedunangst.com>
+//
+// Permission to use, copy, modify, and distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+
+	"golang.org/x/net/html"
+	"humungus.tedunangst.com/r/webs/gate"
+	"humungus.tedunangst.com/r/webs/gencache"
+	"humungus.tedunangst.com/r/webs/htfilter"
+)
+
+type Card struct {
+	URL    string
+	Title  string
+	Desc   string `json:",omitempty"`
+	Image  string `json:",omitempty"`
+	FileID int64  `json:",omitempty"`
+}
+
+var cardTimeout time.Duration = 5
+
+const maxCardPageSize = 512 * 1024
+
+var textfilt htfilter.Filter
+
+func cardblocked(host string) bool {
+	host = strings.ToLower(host)
+	for _, d := range strings.Fields(live().CardDenylist) {
+		d = strings.ToLower(d)
+		if host == d || strings.HasSuffix(host, "."+d) {
+			return true
+		}
+	}
+	return false
+}
+
+func firstlink(h *ActivityPubActivity) string {
+	root, err := html.Parse(strings.NewReader(h.Noise))
+	if err != nil {
+		return ""
+	}
+	mentioned := make(map[string]bool)
+	for _, m := range h.Mentions {
+		mentioned[m.Where] = true
+	}
+	var link string
+	var walk func(node *html.Node)
+	walk = func(node *html.Node) {
+		if link != "" {
+			return
+		}
+		if node.Type == html.ElementNode && node.Data == "a" {
+			href := htfilter.GetAttr(node, "href")
+			rel := htfilter.GetAttr(node, "rel")
+			text := textfilt.NodeText(node)
+			switch {
+			case !strings.HasPrefix(href, "https://") && !strings.HasPrefix(href, "http://"):
+			case htfilter.HasClass(node, "mention") || htfilter.HasClass(node, "hashtag"):
+			case strings.Contains(rel, "tag"):
+			case strings.HasPrefix(text, "@") || strings.HasPrefix(text, "#"):
+			case mentioned[href]:
+			default:
+				link = href
+			}
+			return
+		}
+		for c := node.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(root)
+	return link
+}
+
+var errNotPublic = errors.New("not a public address")
+
+var cgnatnet = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+
+func publicip(ip net.IP) bool {
+	return ip.IsGlobalUnicast() && !ip.IsPrivate() && !cgnatnet.Contains(ip)
+}
+
+// links come from anybody, so don't let them point us inward.
+// checked again when dialing, which also covers redirects.
+func publichost(ctx context.Context, host string) error {
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return err
+	}
+	for _, a := range addrs {
+		if !publicip(a.IP) {
+			return errNotPublic
+		}
+	}
+	return nil
+}
+
+func publicdial(network, address string, c syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); ip == nil || !publicip(ip) {
+		return errNotPublic
+	}
+	return nil
+}
+
+var cardTransport = http.Transport{
+	DialContext:     (&net.Dialer{Control: publicdial}).DialContext,
+	MaxIdleConns:    20,
+	MaxConnsPerHost: 4,
+}
+
+var cardClient = http.Client{
+	Transport: &cardTransport,
+}
+
+func cardget(ctx context.Context, link string, accept string) (*http.Response, error) {
+	u, err := url.Parse(link)
+	if err != nil {
+		return nil, err
+	}
+	err = publichost(ctx, u.Hostname())
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", link, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", "honksnonk/5.0; "+serverName)
+	req.Header.Set("Accept", accept)
+	resp, err := cardClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != 200 {
+		resp.Body.Close()
+		return nil, errCardless
+	}
+	return resp, nil
+}
+
+func fetchcardpage(link string) (*html.Node, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), cardTimeout*time.Second)
+	defer cancel()
+	resp, err := cardget(ctx, link, "text/html")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	media, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if media != "text/html" && media != "application/xhtml+xml" {
+		return nil, errCardless
+	}
+	return html.Parse(io.LimitReader(resp.Body, maxCardPageSize))
+}
+
+func fetchcardimage(link string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), cardTimeout*time.Second)
+	defer cancel()
+	resp, err := cardget(ctx, link, "image/*")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	return io.ReadAll(io.LimitReader(resp.Body, maxFetchSize))
+}
+
+var errCardless = errors.New("no card here")
+
+func cardfrompage(link string, root *html.Node) *Card {
+	props := make(map[string]string)
+	var title string
+	var walk func(node *html.Node)
+	walk = func(node *html.Node) {
+		if node.Type == html.ElementNode {
+			switch node.Data {
+			case "meta":
+				name := htfilter.GetAttr(node, "property")
+				if name == "" {
+					name = htfilter.GetAttr(node, "name")
+				}
+				name = strings.ToLower(name)
+				if _, ok := props[name]; !ok {
+					props[name] = strings.TrimSpace(htfilter.GetAttr(node, "content"))
+				}
+			case "title":
+				if title == "" {
+					title = strings.TrimSpace(textfilt.NodeText(node))
+				}
+			case "body":
+				return
+			}
+		}
+		for c := node.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(root)
+	pick := func(names ...string) string {
+		for _, n := range names {
+			if v := props[n]; v != "" {
+				return v
+			}
+		}
+		return ""
+	}
+	card := new(Card)
+	card.URL = link
+	card.Title = pick("og:title", "twitter:title")
+	if card.Title == "" {
+		card.Title = title
+	}
+	if card.Title == "" {
+		return nil
+	}
+	card.Desc = pick("og:description", "twitter:description", "description")
+	if r := []rune(card.Desc); len(r) > 300 {
+		card.Desc = string(r[:300]) + "..."
+	}
+	if img := pick("og:image", "og:image:url", "twitter:image", "twitter:image:src"); img != "" {
+		base, _ := url.Parse(link)
+		if u, err := base.Parse(img); err == nil && (u.Scheme == "https" || u.Scheme == "http") {
+			card.Image = u.String()
+		}
+	}
+	return card
+}
+
+func savecardimage(card *Card) {
+	imgurl := card.Image
+	card.Image = ""
+	if cardblocked(originate(imgurl)) {
+		return
+	}
+	data, err := fetchcardimage(imgurl)
+	if err != nil {
+		dlog.Printf("no card image %s: %s", imgurl, err)
+		return
+	}
+	img, err := lilshrink(data)
+	if err != nil {
+		ilog.Printf("unable to shrink card image %s: %s", imgurl, err)
+		return
+	}
+	meta := DonkMeta{Length: len(img.Data), Width: img.Width, Height: img.Height}
+	fileid, xid, err := savefileandxid("card", card.Title, "", "image/"+img.Format, true, img.Data, &meta)
+	if err != nil {
+		elog.Printf("error saving card image: %s", err)
+		return
+	}
+	card.Image = xid
+	card.FileID = fileid
+}
+
+var cardcache = gencache.New(gencache.Options[string, *Card]{Fill: func(link string) (*Card, bool) {
+	root, err := fetchcardpage(link)
+	if err != nil {
+		dlog.Printf("no card for %s: %s", link, err)
+		return nil, true
+	}
+	card := cardfrompage(link, root)
+	if card != nil && card.Image != "" {
+		savecardimage(card)
+	}
+	return card, true
+}, Duration: 1 * time.Hour, Limit: 256})
+
+var cardgate = gate.NewLimiter(4)
+
+// find the first link in a honk and attach a preview card to it
+func cardify(h *ActivityPubActivity) {
+	if h.ID == 0 || len(h.Donks) > 0 {
+		return
+	}
+	link := firstlink(h)
+	if link == "" {
+		return
+	}
+	u, err := url.Parse(link)
+	if err != nil || cardblocked(u.Hostname()) {
+		return
+	}
+	if u.Hostname() == serverName || u.Hostname() == masqName {
+		return
+	}
+	cardgate.Start()
+	defer cardgate.Finish()
+	card, _ := cardcache.Get(link)
+	if card == nil {
+		return
+	}
+	j, err := encodeJson(card)
+	if err == nil {
+		stmtDeleteOneMeta.Exec(h.ID, "card")
+		_, err = stmtSaveMeta.Exec(h.ID, "card", j)
+	}
+	if err != nil {
+		elog.Printf("error saving card: %s", err)
+		return
+	}
+	h.Card = card
+}
@@ -0,0 +1,218 @@
+//
+// Copyright (c) 2024 Ted Unangst <tedu@tedunangst.com>
+//
+// Permission to use, copy, modify, and distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"humungus.tedunangst.com/r/webs/login"
+)
+
+// what one chat member's key looks like to us.
+// a new fingerprint means they published a key we haven't trusted.
+type ChatKeyInfo struct {
+	Who            string
+	Fingerprint    string
+	NewFingerprint string
+}
+
+// short enough to read aloud
+func chatfingerprint(pubkey boxPubKey) string {
+	if pubkey.key == nil {
+		return ""
+	}
+	sum := sha256.Sum256(pubkey.key[:])
+	h := hex.EncodeToString(sum[:16])
+	var parts []string
+	for i := 0; i < len(h); i += 4 {
+		parts = append(parts, h[i:i+4])
+	}
+	return strings.Join(parts, " ")
+}
+
+func samechatkey(a, b boxPubKey) bool {
+	return a.key != nil && b.key != nil && *a.key == *b.key
+}
+
+// remote actors may publish a new key whenever they like
+func ingestchatkey(xid string, data string) {
+	old := getxonker(xid, chatKeyProp)
+	if old == data {
+		return
+	}
+	if old != "" && old != "failed" {
+		ilog.Printf("new chat key for %s", xid)
+	}
+	when := time.Now().Add(time.Minute).UTC().Format(dbtimeformat)
+	stmtDeleteXonker.Exec(xid, chatKeyProp, when)
+	savexonker(xid, data, chatKeyProp)
+	chatkeyInvalidator.Clear(xid)
+}
+
+// as published, without asking the webs
+func storedchatkey(xid string) boxPubKey {
+	var pubkey boxPubKey
+	data := getxonker(xid, chatKeyProp)
+	if data != "" && data != "failed" {
+		pubkey.key, _ = b64tokey(data)
+	}
+	return pubkey
+}
+
+func getchatpin(userid UserID, xid string) (boxPubKey, bool) {
+	var pubkey boxPubKey
+	var data string
+	row := stmtGetChatPin.QueryRow(userid, xid)
+	if row.Scan(&data) != nil {
+		return pubkey, false
+	}
+	pubkey.key, _ = b64tokey(data)
+	return pubkey, pubkey.key != nil
+}
+
+func pinchatkey(userid UserID, xid string, pubkey boxPubKey) {
+	dt := time.Now().UTC().Format(dbtimeformat)
+	_, err := stmtDeleteChatPin.Exec(userid, xid)
+	if err == nil {
+		_, err = stmtSaveChatPin.Exec(userid, xid, tob64(pubkey.key[:]), dt)
+	}
+	if err != nil {
+		elog.Printf("error pinning chat key: %s", err)
+	}
+}
+
+// the first key we see is the one we keep using until told otherwise
+func peerchatkey(user *WhatAbout, xid string) (boxPubKey, bool) {
+	if pin, ok := getchatpin(user.ID, xid); ok {
+		return pin, true
+	}
+	pubkey, ok := getchatkey(xid)
+	if ok {
+		pinchatkey(user.ID, xid, pubkey)
+	}
+	return pubkey, ok
+}
+
+func refreshchatkey(xid string) {
+	j, err := GetJunk(firstUserUID, xid)
+	if err != nil {
+		ilog.Printf("error getting %s: %s", xid, err)
+		return
+	}
+	allinjest(originate(xid), j)
+}
+
+// try the pinned key, then whatever they're using now if they say it changed.
+// our old secret keys still open messages sent before we rotated.
+// returns the fingerprint of the key used if it isn't the pinned one.
+func decryptchonk(user *WhatAbout, who string, msg string, sentkey string) (string, string, error) {
+	var pubkeys []boxPubKey
+	pin, pinned := peerchatkey(user, who)
+	if pinned {
+		pubkeys = append(pubkeys, pin)
+	}
+	if sent, err := b64tokey(sentkey); err == nil && (!pinned || *sent != *pin.key) {
+		current := storedchatkey(who)
+		if !samechatkey(current, boxPubKey{sent}) {
+			refreshchatkey(who)
+			current = storedchatkey(who)
+		}
+		if samechatkey(current, boxPubKey{sent}) {
+			pubkeys = append(pubkeys, current)
+		}
+	}
+	seckeys := append([]boxSecKey{user.ChatSecKey}, user.OldChatSecKeys...)
+	for _, pubkey := range pubkeys {
+		for _, seckey := range seckeys {
+			dec, err := decryptString(msg, seckey, pubkey)
+			if err == nil {
+				var newkey string
+				if !pinned || !samechatkey(pubkey, pin) {
+					newkey = chatfingerprint(pubkey)
+				}
+				return dec, newkey, nil
+			}
+		}
+	}
+	return "", "", fmt.Errorf("no key opens chonk from %s", who)
+}
+
+func chatkeyinfo(user *WhatAbout, who string) ChatKeyInfo {
+	info := ChatKeyInfo{Who: who}
+	current := storedchatkey(who)
+	if pin, ok := getchatpin(user.ID, who); ok {
+		info.Fingerprint = chatfingerprint(pin)
+		if current.key != nil && !samechatkey(pin, current) {
+			info.NewFingerprint = chatfingerprint(current)
+		}
+	} else {
+		info.Fingerprint = chatfingerprint(current)
+	}
+	return info
+}
+
+func chatterkeys(user *WhatAbout, chatter []*Chatter) {
+	for _, chat := range chatter {
+		for _, who := range chat.Members() {
+			chat.Keys = append(chat.Keys, chatkeyinfo(user, who))
+		}
+	}
+}
+
+func trustchatkey(user *WhatAbout, who string) {
+	pubkey, ok := getchatkey(who)
+	if !ok {
+		return
+	}
+	ilog.Printf("%s trusts chat key %s for %s", user.Name, chatfingerprint(pubkey), who)
+	pinchatkey(user.ID, who, pubkey)
+}
+
+// the old secret key is kept for anything still in flight
+func rotatechatkeys(user *WhatAbout) error {
+	options := user.Options
+	if options.ChatSecKey != "" {
+		options.OldChatSecKeys = append(options.OldChatSecKeys, options.ChatSecKey)
+	}
+	chatpubkey, chatseckey := newChatKeys()
+	options.ChatPubKey = tob64(chatpubkey.key[:])
+	options.ChatSecKey = tob64(chatseckey.key[:])
+	err := saveoptions(user, options)
+	if err != nil {
+		return err
+	}
+	oldjonkers.Clear(user.Name)
+	ilog.Printf("rotated chat key for %s", user.Name)
+	updateMe(user.Name)
+	return nil
+}
+
+func rotatechatkey(w http.ResponseWriter, r *http.Request) {
+	u := login.GetUserInfo(r)
+	user, _ := getUserBio(u.Username)
+	err := rotatechatkeys(user)
+	if err != nil {
+		elog.Printf("error rotating chat key: %s", err)
+		http.Error(w, "error rotating chat key", http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, "/account", http.StatusSeeOther)
+}
@@ -0,0 +1,369 @@
+//
+// Copyright (c) 2024 Ted Unangst <tedu@tedunangst.com>
+//
+// Permission to use, copy, modify, and distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"humungus.tedunangst.com/r/webs/login"
+)
+
+const chatpagesize = 50
+
+// a chat is identified by everyone else in it, sorted and space separated.
+// a chat with just one other person is just them.
+func chattarget(user *WhatAbout, members []string) string {
+	var others []string
+	for _, m := range members {
+		if m == "" || m == user.URL || m == atContextString || strings.HasSuffix(m, "/followers") {
+			continue
+		}
+		others = append(others, m)
+	}
+	sort.Strings(others)
+	others = stringArrayTrimUntilDupe(others)
+	return strings.Join(others, " ")
+}
+
+func (chat *Chatter) Members() []string {
+	return strings.Fields(chat.Target)
+}
+
+// names may be urls or @handles of known honkers
+func resolvemembers(user *WhatAbout, names string) ([]string, error) {
+	var members []string
+	for _, name := range strings.FieldsFunc(names, func(r rune) bool { return r == ' ' || r == ',' }) {
+		if !strings.HasPrefix(name, "https://") {
+			who := fullname(name, user.ID)
+			if who == "" {
+				return nil, fmt.Errorf("who is %s?", name)
+			}
+			name = who
+		}
+		members = append(members, name)
+	}
+	return members, nil
+}
+
+func getchatgroups(userid UserID) map[string]string {
+	groups := make(map[string]string)
+	rows, err := stmtGetChatGroups.Query(userid)
+	if err != nil {
+		elog.Printf("error querying chat groups: %s", err)
+		return groups
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var target, name string
+		err = rows.Scan(&target, &name)
+		if err != nil {
+			elog.Printf("error scanning chat group: %s", err)
+			continue
+		}
+		groups[target] = name
+	}
+	return groups
+}
+
+func namechatter(userid UserID, chatter []*Chatter) {
+	groups := getchatgroups(userid)
+	for _, chat := range chatter {
+		chat.Name = groups[chat.Target]
+	}
+}
+
+func savechatgroup(userid UserID, target string, name string) error {
+	res, err := stmtRenameChatGroup.Exec(name, userid, target)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		_, err = stmtSaveChatGroup.Exec(userid, target, name)
+	}
+	return err
+}
+
+// a new set of members is a new chat, but the history comes along
+func movechat(userid UserID, oldtarget, newtarget string) error {
+	db := opendatabase()
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	_, err = tx.Exec("update chonks set target = ? where userid = ? and target = ?", newtarget, userid, oldtarget)
+	if err == nil {
+		_, err = tx.Exec("update zonkers set name = ? where userid = ? and name = ? and wherefore in ('chatmute', 'chatarchive')", newtarget, userid, oldtarget)
+	}
+	if err == nil {
+		_, err = tx.Exec("delete from chatgroups where userid = ? and target = ?", userid, newtarget)
+	}
+	if err == nil {
+		_, err = tx.Exec("update chatgroups set target = ? where userid = ? and target = ?", newtarget, userid, oldtarget)
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	return err
+}
+
+// muted and archived chats are zonkers named after the target
+func getchatzonks(userid UserID, wherefore string) map[string]bool {
+	names := make(map[string]bool)
+	rows, err := stmtGetChatZonks.Query(userid, wherefore)
+	if err != nil {
+		elog.Printf("error querying chat zonks: %s", err)
+		return names
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name string
+		err = rows.Scan(&name)
+		if err != nil {
+			elog.Printf("error scanning chat zonk: %s", err)
+			continue
+		}
+		names[name] = true
+	}
+	return names
+}
+
+func chatzonked(userid UserID, target string, wherefore string) bool {
+	var zonkerid int64
+	row := stmtFindChatZonk.QueryRow(userid, target, wherefore)
+	return row.Scan(&zonkerid) == nil
+}
+
+func setchatzonk(userid UserID, target string, wherefore string, on bool) error {
+	_, err := stmtDeleteChatZonk.Exec(userid, target, wherefore)
+	if err == nil && on {
+		_, err = stmtSaveZonker.Exec(userid, target, wherefore)
+	}
+	return err
+}
+
+func getchatters(userid UserID) []string {
+	rows, err := stmtGetChatters.Query(userid, userid)
+	if err != nil {
+		elog.Printf("error querying chatters: %s", err)
+		return nil
+	}
+	defer rows.Close()
+	var targets []string
+	for rows.Next() {
+		var target string
+		err = rows.Scan(&target)
+		if err != nil {
+			elog.Printf("error scanning chatter: %s", err)
+			continue
+		}
+		targets = append(targets, target)
+	}
+	return targets
+}
+
+// older chonks, for one target or all of them, maybe matching some words
+func loadchathistory(userid UserID, target string, search string, before int64) []*Chatter {
+	if before <= 0 {
+		before = math.MaxInt64
+	}
+	like := "%"
+	if search != "" {
+		r := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+		like = "%" + r.Replace(search) + "%"
+	}
+	rows, err := stmtChonkHistory.Query(userid, target, target, like, before, chatpagesize)
+	if err != nil {
+		elog.Printf("error loading chonk history: %s", err)
+		return nil
+	}
+	chonks := scanchonks(rows)
+	archived := getchatzonks(userid, "chatarchive")
+	muted := getchatzonks(userid, "chatmute")
+	var chatter []*Chatter
+	seen := make(map[string]*Chatter)
+	for i := len(chonks) - 1; i >= 0; i-- {
+		ch := chonks[i]
+		chat := seen[ch.Target]
+		if chat == nil {
+			chat = &Chatter{Target: ch.Target, Muted: muted[ch.Target], Archived: archived[ch.Target]}
+			seen[ch.Target] = chat
+			chatter = append(chatter, chat)
+		}
+		chat.Chonks = append(chat.Chonks, ch)
+	}
+	if target != "" && len(chatter) == 0 {
+		chatter = append(chatter, &Chatter{Target: target, Muted: muted[target], Archived: archived[target]})
+	}
+	sortchatter(chatter)
+	namechatter(userid, chatter)
+	return chatter
+}
+
+func archivedchatter(userid UserID) []*Chatter {
+	muted := getchatzonks(userid, "chatmute")
+	var chatter []*Chatter
+	for target := range getchatzonks(userid, "chatarchive") {
+		chatter = append(chatter, &Chatter{Target: target, Muted: muted[target], Archived: true})
+	}
+	sortchatter(chatter)
+	namechatter(userid, chatter)
+	return chatter
+}
+
+// where the next older page starts, if there might be one
+func oldestchonk(chatter []*Chatter) int64 {
+	var oldest int64
+	count := 0
+	for _, chat := range chatter {
+		for _, ch := range chat.Chonks {
+			if oldest == 0 || ch.ID < oldest {
+				oldest = ch.ID
+			}
+			count++
+		}
+	}
+	if count < chatpagesize {
+		return 0
+	}
+	return oldest
+}
+
+func chataction(w http.ResponseWriter, r *http.Request) {
+	u := login.GetUserInfo(r)
+	user, _ := getUserBio(u.Username)
+	userid := user.ID
+	target := r.FormValue("target")
+	var err error
+	switch r.FormValue("action") {
+	case "newgroup":
+		var members []string
+		members, err = resolvemembers(user, r.FormValue("members"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		target = chattarget(user, members)
+		if target == "" {
+			http.Error(w, "a group needs somebody in it", http.StatusBadRequest)
+			return
+		}
+		err = savechatgroup(userid, target, strings.TrimSpace(r.FormValue("name")))
+	case "rename":
+		err = savechatgroup(userid, target, strings.TrimSpace(r.FormValue("name")))
+	case "addmember", "removemember":
+		members := strings.Fields(target)
+		var more []string
+		more, err = resolvemembers(user, r.FormValue("member"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if r.FormValue("action") == "addmember" {
+			members = append(members, more...)
+		} else {
+			gone := make(map[string]bool)
+			for _, m := range more {
+				gone[m] = true
+			}
+			var left []string
+			for _, m := range members {
+				if !gone[m] {
+					left = append(left, m)
+				}
+			}
+			members = left
+		}
+		newtarget := chattarget(user, members)
+		if newtarget == "" {
+			http.Error(w, "somebody has to be left", http.StatusBadRequest)
+			return
+		}
+		if newtarget != target {
+			if _, ok := getchatgroups(userid)[target]; !ok {
+				err = savechatgroup(userid, target, "")
+			}
+			if err == nil {
+				err = movechat(userid, target, newtarget)
+			}
+			target = newtarget
+		}
+	case "trustkey":
+		trustchatkey(user, r.FormValue("member"))
+	case "mute":
+		err = setchatzonk(userid, target, "chatmute", true)
+	case "unmute":
+		err = setchatzonk(userid, target, "chatmute", false)
+	case "archive":
+		err = setchatzonk(userid, target, "chatarchive", true)
+	case "unarchive":
+		err = setchatzonk(userid, target, "chatarchive", false)
+	default:
+		http.Error(w, "unknown action", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		elog.Printf("error updating chat: %s", err)
+	}
+	http.Redirect(w, r, "/chatter?target="+url.QueryEscape(target), http.StatusSeeOther)
+}
+
+func showchathistory(w http.ResponseWriter, r *http.Request) {
+	u := login.GetUserInfo(r)
+	user, _ := getUserBio(u.Username)
+	userid := user.ID
+	target := r.FormValue("target")
+	search := strings.TrimSpace(r.FormValue("q"))
+	before, _ := strconv.ParseInt(r.FormValue("before"), 10, 0)
+	var chatter []*Chatter
+	if r.FormValue("archived") != "" {
+		chatter = archivedchatter(userid)
+	} else {
+		chatter = loadchathistory(userid, target, search, before)
+	}
+	for _, chat := range chatter {
+		for _, ch := range chat.Chonks {
+			filterchonk(ch)
+		}
+	}
+	chatterkeys(user, chatter)
+	templinfo := getInfo(r)
+	templinfo["Chatter"] = chatter
+	templinfo["ChonkCSRF"] = login.GetCSRF("sendchonk", r)
+	templinfo["ChatFingerprint"] = chatfingerprint(user.ChatPubKey)
+	templinfo["ChatTarget"] = target
+	templinfo["ChatSearch"] = search
+	if older := oldestchonk(chatter); older != 0 {
+		q := url.Values{"before": {strconv.FormatInt(older, 10)}}
+		if target != "" {
+			q.Set("target", target)
+		}
+		if search != "" {
+			q.Set("q", search)
+		}
+		templinfo["OlderLink"] = "/chatter?" + q.Encode()
+	}
+	err := readviews.Execute(w, "chatter.html", templinfo)
+	if err != nil {
+		elog.Print(err)
+	}
+}
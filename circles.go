@@ -0,0 +1,221 @@
+//
+// Copyright (c) 2024 Ted Unangst <tedu@tedunangst.com>
+//
+// Permission to use, copy, modify, and distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"humungus.tedunangst.com/r/webs/gencache"
+	"humungus.tedunangst.com/r/webs/httpsig"
+	"humungus.tedunangst.com/r/webs/login"
+)
+
+// a named audience, everyone in some combos plus whoever else was picked
+type Circle struct {
+	ID      int64
+	Name    string
+	Combos  []string
+	Members []string
+}
+
+var re_circlename = regexp.MustCompile("^[\\pL[:digit:]_.-]+$")
+
+var circleInvalidator gencache.Invalidator[UserID]
+var circlecache = gencache.New(gencache.Options[UserID, []*Circle]{Fill: func(userid UserID) ([]*Circle, bool) {
+	rows, err := stmtGetCircles.Query(userid)
+	if err != nil {
+		elog.Printf("error querying circles: %s", err)
+		return nil, false
+	}
+	defer rows.Close()
+	var circles []*Circle
+	for rows.Next() {
+		c := new(Circle)
+		var combos, members string
+		err = rows.Scan(&c.ID, &c.Name, &combos, &members)
+		if err != nil {
+			elog.Printf("error scanning circle: %s", err)
+			continue
+		}
+		c.Combos = strings.Fields(combos)
+		c.Members = strings.Fields(members)
+		circles = append(circles, c)
+	}
+	return circles, true
+}, Invalidator: &circleInvalidator})
+
+func getcircles(userid UserID) []*Circle {
+	circles, _ := circlecache.Get(userid)
+	return circles
+}
+
+func getcircle(userid UserID, name string) *Circle {
+	for _, c := range getcircles(userid) {
+		if c.Name == name {
+			return c
+		}
+	}
+	return nil
+}
+
+// worked out again every time, so new honkers in a combo are included.
+// only people we actually follow, not old or pending ones.
+func circlemembers(userid UserID, c *Circle) []string {
+	members := append([]string{}, c.Members...)
+	if len(c.Combos) > 0 {
+		wanted := make(map[string]bool)
+		for _, combo := range c.Combos {
+			wanted[combo] = true
+		}
+		for _, h := range gethonkers(userid) {
+			if h.Flavor != "sub" && h.Flavor != "peep" {
+				continue
+			}
+			for _, combo := range h.Combos {
+				if wanted[combo] {
+					members = append(members, h.XID)
+					break
+				}
+			}
+		}
+	}
+	sort.Strings(members)
+	return stringArrayTrimUntilDupe(members)
+}
+
+// the audience replaces whatever else it was going to be
+func circlehonk(user *WhatAbout, honk *ActivityPubActivity, name string) error {
+	c := getcircle(user.ID, name)
+	if c == nil {
+		return fmt.Errorf("no circle named %s", name)
+	}
+	members := circlemembers(user.ID, c)
+	if len(members) == 0 {
+		return fmt.Errorf("nobody in circle %s", name)
+	}
+	honk.Circle = c.Name
+	honk.Public = false
+	honk.Audience = append([]string{user.URL}, members...)
+	return nil
+}
+
+// key ids are usually the actor with a fragment
+func keyholder(keyname string, who string) bool {
+	return keyname == who || strings.HasPrefix(keyname, who+"#") || strings.HasPrefix(keyname, who+"/")
+}
+
+// only someone in the audience may fetch a circle honk.
+// servers often sign fetches with their own key instead of the
+// member's, and they hold all the keys anyway, so that counts.
+func circlejonk(user *WhatAbout, r *http.Request, honk *ActivityPubActivity) ([]byte, bool) {
+	donksforhonks([]*ActivityPubActivity{honk})
+	if honk.Circle == "" {
+		return nil, false
+	}
+	keyname, err := httpsig.VerifyRequest(r, nil, getPubKey)
+	if err != nil {
+		dlog.Printf("unsigned fetch of circle honk %s: %s", honk.XID, err)
+		return nil, false
+	}
+	member := false
+	for _, a := range honk.Audience {
+		if a == honk.Honker {
+			continue
+		}
+		if keyholder(keyname, a) || originate(keyname) == originate(a) {
+			member = true
+			break
+		}
+	}
+	if !member {
+		ilog.Printf("refusing circle honk %s to %s", honk.XID, keyname)
+		return nil, false
+	}
+	_, j := jonkjonk(user, honk)
+	if j == nil {
+		return nil, false
+	}
+	j["@context"] = itiswhatitis
+	return j.ToBytes(), true
+}
+
+func savecircle(userid UserID, c *Circle) error {
+	combos := strings.Join(c.Combos, " ")
+	members := strings.Join(c.Members, " ")
+	var err error
+	if c.ID != 0 {
+		_, err = stmtUpdateCircle.Exec(c.Name, combos, members, c.ID, userid)
+	} else {
+		_, err = stmtSaveCircle.Exec(userid, c.Name, combos, members)
+	}
+	circleInvalidator.Clear(userid)
+	return err
+}
+
+func showcircles(w http.ResponseWriter, r *http.Request) {
+	u := login.GetUserInfo(r)
+	userid := UserID(u.UserID)
+	templinfo := getInfo(r)
+	templinfo["CircleList"] = getcircles(userid)
+	templinfo["CircleCSRF"] = login.GetCSRF("circle", r)
+	err := readviews.Execute(w, "circles.html", templinfo)
+	if err != nil {
+		elog.Print(err)
+	}
+}
+
+func circleaction(w http.ResponseWriter, r *http.Request) {
+	u := login.GetUserInfo(r)
+	user, _ := getUserBio(u.Username)
+	circleid, _ := strconv.ParseInt(r.FormValue("circleid"), 10, 0)
+	if r.FormValue("action") == "delete" {
+		_, err := stmtDeleteCircle.Exec(circleid, user.ID)
+		if err != nil {
+			elog.Printf("error deleting circle: %s", err)
+		}
+		circleInvalidator.Clear(user.ID)
+		http.Redirect(w, r, "/circles", http.StatusSeeOther)
+		return
+	}
+	c := &Circle{ID: circleid}
+	c.Name = strings.TrimSpace(r.FormValue("name"))
+	if !re_circlename.MatchString(c.Name) {
+		http.Error(w, "please use a plainer name", http.StatusBadRequest)
+		return
+	}
+	if other := getcircle(user.ID, c.Name); other != nil && other.ID != c.ID {
+		http.Error(w, "there's already a circle with that name", http.StatusBadRequest)
+		return
+	}
+	c.Combos = strings.Fields(r.FormValue("combos"))
+	members, err := resolvemembers(user, r.FormValue("members"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	sort.Strings(members)
+	c.Members = stringArrayTrimUntilDupe(members)
+	err = savecircle(user.ID, c)
+	if err != nil {
+		elog.Printf("error saving circle: %s", err)
+	}
+	http.Redirect(w, r, "/circles", http.StatusSeeOther)
+}
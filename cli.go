@@ -108,12 +108,11 @@ var commands = map[string]cmd{
 		},
 	},
 	"deluser": {
-		help:  "delete a user from honk",
-		help2: "deluser username",
+		help:  "delete a user and tell the world",
+		help2: "deluser username [zipname]",
 		callback: func(args []string) {
-			deluser(args[1])
+			clideluser(args)
 		},
-		nargs: 2,
 	},
 	"chpass": {
 		help:  "change password of an account",
@@ -123,6 +122,46 @@ var commands = map[string]cmd{
 		},
 		nargs: 2,
 	},
+	"sessions": {
+		help:  "list sessions and tokens of an account",
+		help2: "sessions username",
+		callback: func(args []string) {
+			listsessions(args[1])
+		},
+		nargs: 2,
+	},
+	"logout": {
+		help:  "log out an account everywhere, or only some sessions",
+		help2: "logout username [id...]",
+		callback: func(args []string) {
+			if len(args) < 2 {
+				errx("usage: honk logout username [id...]")
+			}
+			logoutuser(args[1], args[2:])
+		},
+	},
+	"expire": {
+		help:  "delete old honks now, or list them",
+		help2: "expire username [dryrun]",
+		callback: func(args []string) {
+			cliexpire(args)
+		},
+	},
+	"invite": {
+		help:  "make a signup link",
+		help2: "invite [duration [uses]]",
+		callback: func(args []string) {
+			cliinvite(args)
+		},
+	},
+	"setadmin": {
+		help:  "grant or take away admin",
+		help2: "setadmin username yes|no",
+		callback: func(args []string) {
+			setadmin(args[1], args[2])
+		},
+		nargs: 3,
+	},
 	"follow": {
 		help:  "follow an account",
 		help2: "follow username url",
@@ -198,6 +237,12 @@ var commands = map[string]cmd{
 			setconfig("usefilestore", 0)
 		},
 	},
+	"dedupfiles": {
+		help: "merge duplicate attachments",
+		callback: func(args []string) {
+			dedupfiles()
+		},
+	},
 	"extractblobs": {
 		help: "extract blobs to file store",
 		callback: func(args []string) {
@@ -249,7 +294,11 @@ var commands = map[string]cmd{
 				errx("user not found")
 			}
 			fmt.Printf("%s\n", user.Options.ChatSecKey)
+			for _, k := range user.Options.OldChatSecKeys {
+				fmt.Printf("%s\n", k)
+			}
 			user.Options.ChatSecKey = ""
+			user.Options.OldChatSecKeys = nil
 			j, err := encodeJson(user.Options)
 			if err == nil {
 				db := opendatabase()
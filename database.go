@@ -35,7 +35,7 @@ import (
 	"humungus.tedunangst.com/r/webs/mz"
 )
 
-var honkwindow time.Duration = 90
+var honkwindow = 90 * 24 * time.Hour
 
 //go:embed schema.sql
 var sqlSchema string
@@ -43,7 +43,8 @@ var sqlSchema string
 func userfromrow(row *sql.Row) (*WhatAbout, error) {
 	user := new(WhatAbout)
 	var seckey, options string
-	err := row.Scan(&user.ID, &user.Name, &user.Display, &user.About, &user.Key, &seckey, &options)
+	var flags int64
+	err := row.Scan(&user.ID, &user.Name, &user.Display, &user.About, &user.Key, &seckey, &options, &flags)
 	if err == nil {
 		user.SecKey, _, err = httpsig.DecodeKey(seckey)
 	}
@@ -56,10 +57,20 @@ func userfromrow(row *sql.Row) (*WhatAbout, error) {
 		if err != nil {
 			elog.Printf("error processing user options: %s", err)
 		}
+		user.Options.Admin = flags&userIsAdmin != 0
+		user.Options.Pending = flags&userIsPending != 0
+		user.Options.Suspended = flags&userIsSuspended != 0
 		user.ChatPubKey.key, _ = b64tokey(user.Options.ChatPubKey)
 		if user.Options.ChatSecKey != "" {
 			user.ChatSecKey.key, _ = b64tokey(user.Options.ChatSecKey)
 		}
+		for _, s := range user.Options.OldChatSecKeys {
+			var seckey boxSecKey
+			seckey.key, _ = b64tokey(s)
+			if seckey.key != nil {
+				user.OldChatSecKeys = append(user.OldChatSecKeys, seckey)
+			}
+		}
 	} else {
 		user.URL = serverURL("/%s", user.Name)
 	}
@@ -152,6 +163,11 @@ func getdubs(userid UserID) []*Honker {
 	return dubsfromrows(rows, err)
 }
 
+func getpendingdubs(userid UserID) []*Honker {
+	rows, err := stmtPendingDubbers.Query(userid)
+	return dubsfromrows(rows, err)
+}
+
 func getnameddubs(userid UserID, name string) []*Honker {
 	rows, err := stmtNamedDubbers.Query(userid, name)
 	return dubsfromrows(rows, err)
@@ -563,7 +579,26 @@ func donksforhonks(honks []*ActivityPubActivity) {
 			h.Link = j
 		case "legalname":
 			h.LegalName = j
+		case "language":
+			h.Language = j
+		case "circle":
+			h.Circle = j
+		case "card":
+			c := new(Card)
+			err = decodeJson(j, c)
+			if err != nil {
+				elog.Printf("error parsing card: %s", err)
+				continue
+			}
+			h.Card = c
 		case "oldrev":
+			var rev OldRevision
+			err = decodeJson(j, &rev)
+			if err != nil {
+				elog.Printf("error parsing oldrev: %s", err)
+				continue
+			}
+			h.Revisions = append(h.Revisions, rev)
 		default:
 			elog.Printf("unknown meta genus: %s", genus)
 		}
@@ -611,7 +646,7 @@ func savechonk(ch *Chonk) error {
 	}
 	defer tx.Rollback()
 
-	res, err := tx.Stmt(stmtSaveChonk).Exec(ch.UserID, ch.XID, ch.Who, ch.Target, dt, ch.Noise, ch.Format)
+	res, err := tx.Stmt(stmtSaveChonk).Exec(ch.UserID, ch.XID, ch.Who, ch.Target, dt, ch.Noise, ch.Format, ch.NewKey)
 	if err == nil {
 		ch.ID, _ = res.LastInsertId()
 		for _, d := range ch.Donks {
@@ -621,8 +656,14 @@ func savechonk(ch *Chonk) error {
 				break
 			}
 		}
-		chatplusone(tx, ch.UserID)
-		err = tx.Commit()
+		// muted chats don't count, and stay archived
+		if !chatzonked(ch.UserID, ch.Target, "chatmute") {
+			chatplusone(tx, ch.UserID)
+			_, err = tx.Stmt(stmtDeleteChatZonk).Exec(ch.UserID, ch.Target, "chatarchive")
+		}
+		if err == nil {
+			err = tx.Commit()
+		}
 	}
 	return err
 }
@@ -701,6 +742,32 @@ func menewnone(userid UserID) {
 	somenumberedusers.Clear(user.ID)
 }
 
+func noticeplusone(userid UserID) {
+	user, ok := somenumberedusers.Get(userid)
+	if !ok {
+		return
+	}
+	options := user.Options
+	options.NoticeCount += 1
+	err := saveoptions(user, options)
+	if err != nil {
+		elog.Printf("error plussing notices: %s", err)
+	}
+}
+
+func noticenewnone(userid UserID) {
+	user, ok := somenumberedusers.Get(userid)
+	if !ok || user.Options.NoticeCount == 0 {
+		return
+	}
+	options := user.Options
+	options.NoticeCount = 0
+	err := saveoptions(user, options)
+	if err != nil {
+		elog.Printf("error noneing notices: %s", err)
+	}
+}
+
 func loadchatter(userid UserID, wanted int64) []*Chatter {
 	duedt := time.Now().Add(-3 * 24 * time.Hour).UTC().Format(dbtimeformat)
 	rows, err := stmtLoadChonks.Query(userid, duedt, wanted)
@@ -708,30 +775,57 @@ func loadchatter(userid UserID, wanted int64) []*Chatter {
 		elog.Printf("error loading chonks: %s", err)
 		return nil
 	}
-	defer rows.Close()
+	allchonks := scanchonks(rows)
 	chonks := make(map[string][]*Chonk)
-	var allchonks []*Chonk
+	for _, ch := range allchonks {
+		chonks[ch.Target] = append(chonks[ch.Target], ch)
+	}
+	archived := getchatzonks(userid, "chatarchive")
+	muted := getchatzonks(userid, "chatmute")
+	if wanted == 0 {
+		// quiet ones are still listed, for their history
+		for _, target := range getchatters(userid) {
+			if _, ok := chonks[target]; !ok {
+				chonks[target] = nil
+			}
+		}
+	}
+	var chatter []*Chatter
+	for target, chonks := range chonks {
+		if archived[target] {
+			continue
+		}
+		chatter = append(chatter, &Chatter{
+			Target: target,
+			Chonks: chonks,
+			Muted:  muted[target],
+		})
+	}
+	sortchatter(chatter)
+	namechatter(userid, chatter)
+	return chatter
+}
+
+func scanchonks(rows *sql.Rows) []*Chonk {
+	defer rows.Close()
+	var chonks []*Chonk
 	for rows.Next() {
 		ch := new(Chonk)
 		var dt string
-		err = rows.Scan(&ch.ID, &ch.UserID, &ch.XID, &ch.Who, &ch.Target, &dt, &ch.Noise, &ch.Format)
+		err := rows.Scan(&ch.ID, &ch.UserID, &ch.XID, &ch.Who, &ch.Target, &dt, &ch.Noise, &ch.Format, &ch.NewKey)
 		if err != nil {
 			elog.Printf("error scanning chonk: %s", err)
 			continue
 		}
 		ch.Date, _ = time.Parse(dbtimeformat, dt)
-		chonks[ch.Target] = append(chonks[ch.Target], ch)
-		allchonks = append(allchonks, ch)
+		chonks = append(chonks, ch)
 	}
 	rows.Close()
-	donksforchonks(allchonks)
-	var chatter []*Chatter
-	for target, chonks := range chonks {
-		chatter = append(chatter, &Chatter{
-			Target: target,
-			Chonks: chonks,
-		})
-	}
+	donksforchonks(chonks)
+	return chonks
+}
+
+func sortchatter(chatter []*Chatter) {
 	sort.Slice(chatter, func(i, j int) bool {
 		a, b := chatter[i], chatter[j]
 		if len(a.Chonks) == 0 || len(b.Chonks) == 0 {
@@ -742,7 +836,6 @@ func loadchatter(userid UserID, wanted int64) []*Chatter {
 		}
 		return a.Chonks[len(a.Chonks)-1].Date.After(b.Chonks[len(b.Chonks)-1].Date)
 	})
-	return chatter
 }
 
 func (honk *ActivityPubActivity) Plain() string {
@@ -815,7 +908,9 @@ func savehonk(h *ActivityPubActivity) error {
 
 func updatehonk(h *ActivityPubActivity) error {
 	old := getActivityPubActivity(h.UserID, h.XID)
-	oldrev := OldRevision{Precis: old.Precis, Noise: old.Noise}
+	donksforhonks([]*ActivityPubActivity{old})
+	oldrev := OldRevision{Precis: old.Precis, Noise: old.Noise, Format: old.Format, Date: old.Date,
+		Alts: donkalts(old.Donks)}
 	dt := h.Date.UTC().Format(dbtimeformat)
 
 	db := opendatabase()
@@ -834,7 +929,8 @@ func updatehonk(h *ActivityPubActivity) error {
 	if err == nil {
 		err = saveextras(tx, h)
 	}
-	if err == nil {
+	// reattached files and such don't need a revision
+	if err == nil && (oldrev.Precis != h.Precis || oldrev.Noise != h.Noise) {
 		var j string
 		j, err = encodeJson(&oldrev)
 		if err == nil {
@@ -853,6 +949,69 @@ func updatehonk(h *ActivityPubActivity) error {
 	return err
 }
 
+// image descriptions, as of a revision
+func donkalts(donks []*Donk) []string {
+	alts := []string{}
+	for _, d := range donks {
+		if strings.HasPrefix(d.Media, "image/") && !undescribed(d) {
+			alts = append(alts, d.Desc)
+		}
+	}
+	return alts
+}
+
+// change one honk's description of an attachment.
+// other honks using the same file keep theirs.
+func describehonkdonk(h *ActivityPubActivity, donk *Donk, desc string) error {
+	oldrev := OldRevision{Precis: h.Precis, Noise: h.Noise, Format: h.Format, Date: h.Date,
+		Alts: donkalts(h.Donks)}
+
+	db := opendatabase()
+	tx, err := db.Begin()
+	if err != nil {
+		elog.Printf("can't begin tx: %s", err)
+		return err
+	}
+	defer tx.Rollback()
+
+	fileid := donk.FileID
+	var others int
+	err = tx.Stmt(stmtFileShared).QueryRow(fileid, h.ID).Scan(&others)
+	if err == nil && others > 0 {
+		var res sql.Result
+		res, err = tx.Stmt(stmtCopyFileMeta).Exec(desc, fileid)
+		if err == nil {
+			fileid, err = res.LastInsertId()
+		}
+		if err == nil {
+			_, err = tx.Stmt(stmtMoveDonk).Exec(fileid, h.ID, donk.FileID)
+		}
+	} else if err == nil {
+		_, err = tx.Stmt(stmtSetFileDesc).Exec(desc, fileid)
+	}
+	if err == nil {
+		donk.FileID = fileid
+		donk.Desc = desc
+		h.Date = time.Now().UTC()
+		dt := h.Date.Format(dbtimeformat)
+		_, err = tx.Stmt(stmtUpdateHonk).Exec(h.Precis, h.Noise, h.Format, h.Whofore, dt, h.Plain(), h.ID)
+	}
+	if err == nil {
+		var j string
+		j, err = encodeJson(&oldrev)
+		if err == nil {
+			_, err = tx.Stmt(stmtSaveMeta).Exec(h.ID, "oldrev", j)
+		}
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		elog.Printf("error describing donk %d: %s", donk.FileID, err)
+	}
+	return err
+}
+
 func deletehonk(honkid int64) error {
 	db := opendatabase()
 	tx, err := db.Begin()
@@ -948,6 +1107,20 @@ func saveextras(tx *sql.Tx, h *ActivityPubActivity) error {
 			return err
 		}
 	}
+	if lang := h.Language; lang != "" {
+		_, err := tx.Stmt(stmtSaveMeta).Exec(h.ID, "language", lang)
+		if err != nil {
+			elog.Printf("error saving language: %s", err)
+			return err
+		}
+	}
+	if circle := h.Circle; circle != "" {
+		_, err := tx.Stmt(stmtSaveMeta).Exec(h.ID, "circle", circle)
+		if err != nil {
+			elog.Printf("error saving circle: %s", err)
+			return err
+		}
+	}
 	return nil
 }
 
@@ -970,6 +1143,9 @@ func addreaction(user *WhatAbout, xid string, who, react string) {
 	_, _ = tx.Stmt(stmtDeleteOneMeta).Exec(h.ID, "badonks")
 	_, _ = tx.Stmt(stmtSaveMeta).Exec(h.ID, "badonks", j)
 	tx.Commit()
+	if h.Honker == user.URL {
+		notify(user, noticeReaction, who, xid, react)
+	}
 }
 
 func deleteextras(tx *sql.Tx, honkid int64, everything bool) error {
@@ -1095,8 +1271,43 @@ func cleanupdb(arg string) {
 	sqlMustQuery(db, "delete from donks where honkid > 0 and honkid not in (select honkid from honks)")
 	sqlMustQuery(db, "delete from onts where honkid not in (select honkid from honks)")
 	sqlMustQuery(db, "delete from honkmeta where honkid not in (select honkid from honks)")
+	if err == nil {
+		sqlMustQuery(db, "delete from notices where seen = 1 and dt < ?", sqlargs[0])
+	}
 
-	sqlMustQuery(db, "delete from filemeta where fileid not in (select fileid from donks)")
+	cardfiles := make(map[int64]bool)
+	rows, err := db.Query("select json from honkmeta where genus = 'card'")
+	checkErr(err)
+	for rows.Next() {
+		var j string
+		var card Card
+		err = rows.Scan(&j)
+		checkErr(err)
+		if decodeJson(j, &card) == nil && card.FileID != 0 {
+			cardfiles[card.FileID] = true
+		}
+	}
+	rows.Close()
+	draftfiles := draftfiles()
+	var orphans []int64
+	rows, err = db.Query("select fileid from filemeta where fileid not in (select fileid from donks)")
+	checkErr(err)
+	for rows.Next() {
+		var fileid int64
+		err = rows.Scan(&fileid)
+		checkErr(err)
+		if !cardfiles[fileid] && !draftfiles[fileid] {
+			orphans = append(orphans, fileid)
+		}
+	}
+	rows.Close()
+	tx, err := db.Begin()
+	checkErr(err)
+	for _, fileid := range orphans {
+		sqlMustQuery(tx, "delete from filemeta where fileid = ?", fileid)
+	}
+	err = tx.Commit()
+	checkErr(err)
 	for _, u := range allusers() {
 		sqlMustQuery(db, "delete from zonkers where userid = ? and wherefore = 'zonvoy' and zonkerid < (select zonkerid from zonkers where userid = ? and wherefore = 'zonvoy' order by zonkerid desc limit 1 offset 200)", u.UserID, u.UserID)
 	}
@@ -1111,18 +1322,37 @@ var stmtUserHonksNoReply *sql.Stmt
 var stmtHonksByOntology, stmtHonksForUser, stmtHonksForMe, stmtSaveDub, stmtHonksByXonker *sql.Stmt
 var sqlHonksFromLongAgo string
 var stmtHonksByHonker, stmtSaveHonk, stmtUserByName, stmtUserByNumber *sql.Stmt
+var stmtSetUserFlags, stmtClearUserFlags *sql.Stmt
 var stmtEventHonks, stmtOneBonk, stmtFindZonk, stmtFindXonk, stmtSaveDonk *sql.Stmt
-var stmtGetFileInfo, stmtFindFile, stmtFindFileId, stmtSaveFile *sql.Stmt
-var stmtGetFileMedia, stmtSaveFileHash, stmtCheckFileHash *sql.Stmt
+var stmtGetFileInfo, stmtFindFile, stmtFindRemoteFile, stmtFindFileId, stmtSaveFile *sql.Stmt
+var stmtGetFileDesc, stmtSetFileDesc, stmtSetPendingFileDesc *sql.Stmt
+var stmtFileShared, stmtCopyFileMeta, stmtMoveDonk *sql.Stmt
+var stmtGetFileMedia, stmtSaveFileHash, stmtCheckFileHash, stmtFileTwins *sql.Stmt
 var stmtAddDoover, stmtGetDoovers, stmtLoadDoover, stmtZapDoover, stmtOneHonker *sql.Stmt
 var stmtUntagged, stmtDeleteHonk, stmtDeleteDonks, stmtDeleteOnts, stmtSaveZonker *sql.Stmt
 var stmtGetZonkers, stmtRecentHonkers, stmtGetXonker, stmtSaveXonker, stmtDeleteXonker, stmtDeleteOldXonkers *sql.Stmt
 var stmtAllOnts, stmtSaveOnt, stmtUpdateFlags, stmtClearFlags *sql.Stmt
 var stmtHonksForUserFirstClass *sql.Stmt
 var stmtSaveMeta, stmtDeleteAllMeta, stmtDeleteOneMeta, stmtDeleteSomeMeta, stmtUpdateHonk *sql.Stmt
-var stmtHonksISaved, stmtGetFilters, stmtSaveFilter, stmtDeleteFilter *sql.Stmt
+var stmtHonksISaved, stmtGetFilters, stmtSaveFilter, stmtDeleteFilter, stmtUpdateFilter, stmtFilterHits, stmtFilterTestHonks *sql.Stmt
+var stmtGetAuth, stmtAuthUsed, stmtAuthSeen, stmtSaveAppToken, stmtGetAuths, stmtGetAuthHash *sql.Stmt
+var stmtDeleteOneAuth, stmtDeleteOtherAuth, stmtDeleteSessions, stmtSaveSession *sql.Stmt
+var stmtGetPasskeys, stmtFindPasskey, stmtSavePasskey, stmtUsedPasskey, stmtDeletePasskey *sql.Stmt
+var stmtSaveRecoveryCode, stmtCountRecoveryCodes, stmtUseRecoveryCode, stmtDeleteRecoveryCodes *sql.Stmt
+var stmtUserFileMeta, stmtRecentHonks *sql.Stmt
+var stmtExpiringHonks, stmtCountReplies *sql.Stmt
+var stmtSaveDraft, stmtUpdateDraft, stmtGetDraft, stmtGetDrafts, stmtDeleteDraft *sql.Stmt
+var stmtScheduleDraft, stmtScheduledDrafts *sql.Stmt
+var stmtSaveInvite, stmtGetInvite, stmtGetInvites, stmtUseInvite, stmtUnuseInvite, stmtDeleteInvite *sql.Stmt
 var stmtGetTracks *sql.Stmt
 var stmtSaveChonk, stmtLoadChonks, stmtGetChatters *sql.Stmt
+var stmtChonkHistory, stmtGetChatZonks, stmtFindChatZonk, stmtDeleteChatZonk *sql.Stmt
+var stmtGetChatGroups, stmtSaveChatGroup, stmtRenameChatGroup *sql.Stmt
+var stmtGetChatPin, stmtSaveChatPin, stmtDeleteChatPin *sql.Stmt
+var stmtGetSpamSamples, stmtSaveSpamSample, stmtDeleteSpamSample, stmtPruneSpamSamples, stmtSpamHonks *sql.Stmt
+var stmtSaveNotice, stmtFindNotice, stmtGetNotices, stmtSeeNotices *sql.Stmt
+var stmtPendingDubbers *sql.Stmt
+var stmtGetCircles, stmtSaveCircle, stmtUpdateCircle, stmtDeleteCircle *sql.Stmt
 var stmtGetTopDubbed *sql.Stmt
 var stmtDeliquentCheck, stmtDeliquentUpdate *sql.Stmt
 var stmtGetBlobData, stmtSaveBlobData *sql.Stmt
@@ -1159,6 +1389,11 @@ func prepareStatements(db *sql.DB) {
 	stmtOneHonker = sqlMustPrepare(db, "select xid from honkers where name = ? and userid = ?")
 	stmtDubbers = sqlMustPrepare(db, "select honkerid, userid, name, xid, flavor from honkers where userid = ? and flavor = 'dub'")
 	stmtNamedDubbers = sqlMustPrepare(db, "select honkerid, userid, name, xid, flavor from honkers where userid = ? and name = ? and flavor = 'dub'")
+	stmtGetCircles = sqlMustPrepare(db, "select circleid, name, combos, members from circles where userid = ? order by name")
+	stmtSaveCircle = sqlMustPrepare(db, "insert into circles (userid, name, combos, members) values (?, ?, ?, ?)")
+	stmtUpdateCircle = sqlMustPrepare(db, "update circles set name = ?, combos = ?, members = ? where circleid = ? and userid = ?")
+	stmtDeleteCircle = sqlMustPrepare(db, "delete from circles where circleid = ? and userid = ?")
+	stmtPendingDubbers = sqlMustPrepare(db, "select honkerid, userid, name, xid, flavor from honkers where userid = ? and flavor = 'predub'")
 
 	selecthonks := "select honks.honkid, honks.userid, username, what, honker, oonker, honks.xid, rid, dt, url, audience, noise, precis, format, convoy, whofore, flags from honks join users on honks.userid = users.userid "
 	limit := " order by honks.honkid desc limit 250"
@@ -1177,6 +1412,9 @@ func prepareStatements(db *sql.DB) {
 	stmtHonksForMe = sqlMustPrepare(db, selecthonks+"where honks.honkid > ? and honks.userid = ? and dt > ? and whofore = 1"+butnotthose+smalllimit)
 	sqlHonksFromLongAgo = selecthonks + "where honks.honkid > ? and honks.userid = ? and (WHERECLAUSE) and (whofore = 2 or flags & 4)" + butnotthose + limit
 	stmtHonksISaved = sqlMustPrepare(db, selecthonks+"where honks.honkid > ? and honks.userid = ? and flags & 4 order by honks.honkid desc")
+	stmtFilterTestHonks = sqlMustPrepare(db, selecthonks+"where honks.userid = ? and honker <> ?"+smalllimit)
+	stmtSpamHonks = sqlMustPrepare(db, selecthonks+"where honks.userid = ? and flags & ?"+limit)
+	stmtExpiringHonks = sqlMustPrepare(db, selecthonks+"where honks.honkid > ? and honks.userid = ? and whofore in (2, 3) and dt < ? and flags & 4 = 0 order by honks.honkid asc limit ?")
 	stmtHonksByHonker = sqlMustPrepare(db, selecthonks+"join honkers on (honkers.xid = honks.honker or honkers.xid = honks.oonker) where honks.honkid > ? and honks.userid = ? and honkers.name = ?"+butnotthose+limit)
 	stmtHonksByXonker = sqlMustPrepare(db, selecthonks+" where honks.honkid > ? and honks.userid = ? and (honker = ? or oonker = ?)"+butnotthose+limit)
 	stmtHonksByCombo = sqlMustPrepare(db, selecthonks+" where honks.honkid > ? and honks.userid = ? and honks.honker in (select xid from honkers where honkers.userid = ? and honkers.combos like ?) "+butnotthose+" union "+selecthonks+"join onts on honks.honkid = onts.honkid where honks.honkid > ? and honks.userid = ? and onts.ontology in (select xid from honkers where combos like ?)"+butnotthose+limit)
@@ -1204,14 +1442,24 @@ func prepareStatements(db *sql.DB) {
 	stmtDeleteDonks = sqlMustPrepare(db, "delete from donks where honkid = ?")
 	stmtSaveFile = sqlMustPrepare(db, "insert into filemeta (xid, name, description, url, media, local, meta) values (?, ?, ?, ?, ?, ?, ?)")
 	stmtSaveFileHash = sqlMustPrepare(db, "insert into filehashes (xid, hash, media) values (?, ?, ?)")
+	stmtFileTwins = sqlMustPrepare(db, "select xid from filehashes where hash = (select hash from filehashes where xid = ?) and xid <> ? order by rowid")
 	stmtCheckFileHash = sqlMustPrepare(db, "select xid from filehashes where hash = ?")
 	stmtGetFileMedia = sqlMustPrepare(db, "select media from filehashes where xid = ?")
 	stmtFindXonk = sqlMustPrepare(db, "select honkid from honks where userid = ? and xid = ?")
 	stmtGetFileInfo = sqlMustPrepare(db, "select url from filemeta where xid = ?")
 	stmtFindFile = sqlMustPrepare(db, "select fileid, xid from filemeta where url = ? and local = 1")
+	stmtFindRemoteFile = sqlMustPrepare(db, "select media from filemeta where url = ? and local = 0 limit 1")
 	stmtFindFileId = sqlMustPrepare(db, "select xid, local, description from filemeta where fileid = ? and url = ? and local = 1")
-	stmtUserByName = sqlMustPrepare(db, "select userid, username, displayname, about, pubkey, seckey, options from users where username = ? and userid > 0")
-	stmtUserByNumber = sqlMustPrepare(db, "select userid, username, displayname, about, pubkey, seckey, options from users where userid = ?")
+	stmtGetFileDesc = sqlMustPrepare(db, "select name, description, url, media from filemeta where fileid = ?")
+	stmtSetFileDesc = sqlMustPrepare(db, "update filemeta set description = ? where fileid = ? and local = 1")
+	stmtFileShared = sqlMustPrepare(db, "select count(*) from donks where fileid = ? and honkid <> ?")
+	stmtCopyFileMeta = sqlMustPrepare(db, "insert into filemeta (xid, name, description, url, media, local, meta) select xid, name, ?, url, media, local, meta from filemeta where fileid = ?")
+	stmtMoveDonk = sqlMustPrepare(db, "update donks set fileid = ? where honkid = ? and fileid = ?")
+	stmtSetPendingFileDesc = sqlMustPrepare(db, "update filemeta set description = ? where fileid = ? and local = 1 and fileid not in (select fileid from donks)")
+	stmtUserByName = sqlMustPrepare(db, "select userid, username, displayname, about, pubkey, seckey, options, userflags from users where username = ? and userid > 0")
+	stmtUserByNumber = sqlMustPrepare(db, "select userid, username, displayname, about, pubkey, seckey, options, userflags from users where userid = ?")
+	stmtSetUserFlags = sqlMustPrepare(db, "update users set userflags = userflags | ? where userid = ?")
+	stmtClearUserFlags = sqlMustPrepare(db, "update users set userflags = userflags & ~ ? where userid = ?")
 	stmtSaveDub = sqlMustPrepare(db, "insert into honkers (userid, name, xid, flavor, combos, owner, meta, folxid) values (?, ?, ?, ?, '', '', '', ?)")
 	stmtAddDoover = sqlMustPrepare(db, "insert into doovers (dt, tries, userid, rcpt, msg) values (?, ?, ?, ?, ?)")
 	stmtGetDoovers = sqlMustPrepare(db, "select dooverid, dt from doovers")
@@ -1229,13 +1477,68 @@ func prepareStatements(db *sql.DB) {
 	stmtUpdateFlags = sqlMustPrepare(db, "update honks set flags = flags | ? where honkid = ?")
 	stmtClearFlags = sqlMustPrepare(db, "update honks set flags = flags & ~ ? where honkid = ?")
 	stmtAllOnts = sqlMustPrepare(db, "select ontology, count(ontology) from onts join honks on onts.honkid = honks.honkid where (honks.userid = ? or honks.whofore = 2) group by ontology")
-	stmtGetFilters = sqlMustPrepare(db, "select hfcsid, json from hfcs where userid = ?")
-	stmtSaveFilter = sqlMustPrepare(db, "insert into hfcs (userid, json) values (?, ?)")
+	stmtGetFilters = sqlMustPrepare(db, "select hfcsid, json, hits, lasthit from hfcs where userid = ?")
+	stmtSaveFilter = sqlMustPrepare(db, "insert into hfcs (userid, json, hits, lasthit) values (?, ?, 0, '')")
 	stmtDeleteFilter = sqlMustPrepare(db, "delete from hfcs where userid = ? and hfcsid = ?")
+	stmtUpdateFilter = sqlMustPrepare(db, "update hfcs set json = ? where hfcsid = ? and userid = ?")
+	stmtFilterHits = sqlMustPrepare(db, "update hfcs set hits = hits + ?, lasthit = ? where hfcsid = ? and userid = ?")
+	stmtGetAuth = sqlMustPrepare(db, "select authid, userid, scopes, coalesce(deadline, ''), coalesce(ip, ''), coalesce(agent, '') from auth where hash = ? and expiry > ?")
+	stmtAuthUsed = sqlMustPrepare(db, "update auth set lastused = ?, created = coalesce(created, ?) where authid = ?")
+	stmtAuthSeen = sqlMustPrepare(db, "update auth set ip = ?, agent = ? where authid = ?")
+	stmtSaveAppToken = sqlMustPrepare(db, "insert into auth (userid, hash, expiry, name, scopes, created, deadline) values (?, ?, ?, ?, ?, ?, ?)")
+	stmtGetAuths = sqlMustPrepare(db, "select authid, coalesce(name, ''), scopes, coalesce(created, ''), expiry, coalesce(lastused, ''), coalesce(deadline, ''), coalesce(ip, ''), coalesce(agent, '') from auth where userid = ? order by authid")
+	stmtGetAuthHash = sqlMustPrepare(db, "select hash from auth where authid = ? and userid = ?")
+	stmtDeleteOneAuth = sqlMustPrepare(db, "delete from auth where authid = ? and userid = ?")
+	stmtDeleteOtherAuth = sqlMustPrepare(db, "delete from auth where userid = ? and authid <> ?")
+	stmtDeleteSessions = sqlMustPrepare(db, "delete from auth where userid = ? and scopes is null")
+	stmtSaveSession = sqlMustPrepare(db, "insert into auth (userid, hash, expiry, created) values (?, ?, ?, ?)")
+	stmtGetPasskeys = sqlMustPrepare(db, "select passkeyid, userid, credid, pubkey, signcount, name, created, coalesce(lastused, '') from passkeys where userid = ?")
+	stmtFindPasskey = sqlMustPrepare(db, "select passkeyid, userid, credid, pubkey, signcount, name, created, coalesce(lastused, '') from passkeys where credid = ?")
+	stmtSavePasskey = sqlMustPrepare(db, "insert into passkeys (userid, credid, pubkey, signcount, name, created) values (?, ?, ?, ?, ?, ?)")
+	stmtUsedPasskey = sqlMustPrepare(db, "update passkeys set signcount = ?, lastused = ? where passkeyid = ?")
+	stmtDeletePasskey = sqlMustPrepare(db, "delete from passkeys where passkeyid = ? and userid = ?")
+	stmtSaveRecoveryCode = sqlMustPrepare(db, "insert into recoverycodes (userid, hash) values (?, ?)")
+	stmtCountRecoveryCodes = sqlMustPrepare(db, "select count(*) from recoverycodes where userid = ?")
+	stmtUseRecoveryCode = sqlMustPrepare(db, "delete from recoverycodes where userid = ? and hash = ?")
+	stmtDeleteRecoveryCodes = sqlMustPrepare(db, "delete from recoverycodes where userid = ?")
+	stmtUserFileMeta = sqlMustPrepare(db, "select meta from filemeta where local = 1 and fileid in (select fileid from donks join honks on donks.honkid = honks.honkid where honks.userid = ? and whofore in (2, 3) and what <> 'bonk' union select fileid from donks join chonks on donks.chonkid = chonks.chonkid where chonks.userid = ? and chonks.who = ?)")
+	stmtRecentHonks = sqlMustPrepare(db, "select count(*) from honks where userid = ? and whofore in (2, 3) and dt > ?")
+	stmtCountReplies = sqlMustPrepare(db, "select count(*) from honks where rid = ?")
+	stmtSaveDraft = sqlMustPrepare(db, "insert into drafts (userid, dt, publish, form) values (?, ?, ?, ?)")
+	stmtUpdateDraft = sqlMustPrepare(db, "update drafts set dt = ?, publish = ?, form = ? where draftid = ? and userid = ?")
+	stmtGetDraft = sqlMustPrepare(db, "select draftid, userid, dt, publish, form from drafts where draftid = ? and userid = ?")
+	stmtGetDrafts = sqlMustPrepare(db, "select draftid, userid, dt, publish, form from drafts where userid = ? order by draftid desc")
+	stmtDeleteDraft = sqlMustPrepare(db, "delete from drafts where draftid = ? and userid = ?")
+	stmtScheduleDraft = sqlMustPrepare(db, "update drafts set publish = ? where draftid = ? and userid = ?")
+	stmtScheduledDrafts = sqlMustPrepare(db, "select draftid, userid, dt, publish, form from drafts where publish <> '' order by publish asc")
+	stmtSaveInvite = sqlMustPrepare(db, "insert into invites (userid, code, created, expiry, maxuses, uses, approval) values (?, ?, ?, ?, ?, 0, ?)")
+	stmtGetInvite = sqlMustPrepare(db, "select inviteid, userid, code, created, expiry, maxuses, uses, approval from invites where code = ?")
+	stmtGetInvites = sqlMustPrepare(db, "select inviteid, userid, code, created, expiry, maxuses, uses, approval from invites order by inviteid desc")
+	stmtUseInvite = sqlMustPrepare(db, "update invites set uses = uses + 1 where inviteid = ? and uses < maxuses and expiry > ?")
+	stmtUnuseInvite = sqlMustPrepare(db, "update invites set uses = uses - 1 where inviteid = ? and uses > 0")
+	stmtDeleteInvite = sqlMustPrepare(db, "delete from invites where inviteid = ?")
 	stmtGetTracks = sqlMustPrepare(db, "select fetches from tracks where xid = ?")
-	stmtSaveChonk = sqlMustPrepare(db, "insert into chonks (userid, xid, who, target, dt, noise, format) values (?, ?, ?, ?, ?, ?, ?)")
-	stmtLoadChonks = sqlMustPrepare(db, "select chonkid, userid, xid, who, target, dt, noise, format from chonks where userid = ? and dt > ? and chonkid > ? order by chonkid asc")
-	stmtGetChatters = sqlMustPrepare(db, "select distinct(target) from chonks where userid = ?")
+	stmtSaveChonk = sqlMustPrepare(db, "insert into chonks (userid, xid, who, target, dt, noise, format, newkey) values (?, ?, ?, ?, ?, ?, ?, ?)")
+	stmtLoadChonks = sqlMustPrepare(db, "select chonkid, userid, xid, who, target, dt, noise, format, newkey from chonks where userid = ? and dt > ? and chonkid > ? order by chonkid asc")
+	stmtGetChatters = sqlMustPrepare(db, "select distinct(target) from chonks where userid = ? union select target from chatgroups where userid = ?")
+	stmtChonkHistory = sqlMustPrepare(db, "select chonkid, userid, xid, who, target, dt, noise, format, newkey from chonks where userid = ? and (? = '' or target = ?) and noise like ? escape '\\' and chonkid < ? order by chonkid desc limit ?")
+	stmtGetChatZonks = sqlMustPrepare(db, "select name from zonkers where userid = ? and wherefore = ?")
+	stmtFindChatZonk = sqlMustPrepare(db, "select zonkerid from zonkers where userid = ? and name = ? and wherefore = ?")
+	stmtDeleteChatZonk = sqlMustPrepare(db, "delete from zonkers where userid = ? and name = ? and wherefore = ?")
+	stmtGetChatGroups = sqlMustPrepare(db, "select target, name from chatgroups where userid = ?")
+	stmtSaveChatGroup = sqlMustPrepare(db, "insert into chatgroups (userid, target, name) values (?, ?, ?)")
+	stmtRenameChatGroup = sqlMustPrepare(db, "update chatgroups set name = ? where userid = ? and target = ?")
+	stmtGetChatPin = sqlMustPrepare(db, "select pubkey from chatpins where userid = ? and xid = ?")
+	stmtSaveChatPin = sqlMustPrepare(db, "insert into chatpins (userid, xid, pubkey, dt) values (?, ?, ?, ?)")
+	stmtDeleteChatPin = sqlMustPrepare(db, "delete from chatpins where userid = ? and xid = ?")
+	stmtGetSpamSamples = sqlMustPrepare(db, "select spam, words from spamsamples where userid = ?")
+	stmtSaveSpamSample = sqlMustPrepare(db, "insert into spamsamples (userid, xid, spam, words, dt) values (?, ?, ?, ?, ?)")
+	stmtDeleteSpamSample = sqlMustPrepare(db, "delete from spamsamples where userid = ? and xid = ?")
+	stmtPruneSpamSamples = sqlMustPrepare(db, "delete from spamsamples where userid = ? and spam = ? and sampleid not in (select sampleid from spamsamples where userid = ? and spam = ? order by sampleid desc limit ?)")
+	stmtSaveNotice = sqlMustPrepare(db, "insert into notices (userid, what, who, xid, content, dt, seen) values (?, ?, ?, ?, ?, ?, 0)")
+	stmtFindNotice = sqlMustPrepare(db, "select noticeid from notices where userid = ? and what = ? and who = ? and xid = ?")
+	stmtGetNotices = sqlMustPrepare(db, "select noticeid, what, who, xid, content, dt, seen from notices where userid = ? and noticeid > ? order by noticeid desc limit ?")
+	stmtSeeNotices = sqlMustPrepare(db, "update notices set seen = 1 where userid = ? and noticeid <= ? and seen = 0")
 	stmtGetTopDubbed = sqlMustPrepare(db, `SELECT COUNT(*) as c,userid FROM honkers WHERE flavor = "dub" GROUP BY userid`)
 	stmtDeliquentCheck = sqlMustPrepare(db, "select dooverid, msg from doovers where userid = ? and rcpt = ?")
 	stmtDeliquentUpdate = sqlMustPrepare(db, "update doovers set msg = ? where dooverid = ?")
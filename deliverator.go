@@ -134,6 +134,11 @@ func deliveration(doover Doover) {
 		elog.Printf("lost key for delivery")
 		return
 	}
+	// deleted users still have deletes to send
+	if user, ok := somenumberedusers.Get(doover.Userid); ok && suspended(user) && !user.Options.Deleted {
+		ilog.Printf("dropping delivery for suspended user %s", user.Name)
+		return
+	}
 	var inbox string
 	// already did the box indirection
 	if rcpt[0] == '%' {
@@ -0,0 +1,399 @@
+//
+// Copyright (c) 2024 Ted Unangst <tedu@tedunangst.com>
+//
+// Permission to use, copy, modify, and distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"humungus.tedunangst.com/r/webs/login"
+)
+
+// A draft is the compose form, saved as is.
+// Scheduled honks are drafts with a publish time.
+type Draft struct {
+	ID      int64
+	UserID  UserID
+	Date    time.Time
+	Publish time.Time
+	Form    url.Values
+}
+
+func (d *Draft) Noise() string {
+	return d.Form.Get("noise")
+}
+
+func (d *Draft) InReplyTo() string {
+	return d.Form.Get("rid")
+}
+
+func (d *Draft) UpdateXID() string {
+	return d.Form.Get("updatexid")
+}
+
+// not saved with the draft
+var draftskipfields = []string{"CSRF", "draft", "draftid", "preview", "publishat"}
+
+var draftchan = make(chan bool, 1)
+
+func pokedrafts() {
+	select {
+	case draftchan <- true:
+	default:
+	}
+}
+
+// same layouts as event times, and a bare time means the next one
+func parsepublishtime(s string) (time.Time, error) {
+	now := time.Now().Local()
+	for _, layout := range []string{"2006-01-02 3:04pm", "2006-01-02 15:04", "3:04pm", "15:04"} {
+		when, err := time.ParseInLocation(layout, s, now.Location())
+		if err != nil {
+			continue
+		}
+		if when.Year() == 0 {
+			when = time.Date(now.Year(), now.Month(), now.Day(), when.Hour(), when.Minute(), 0, 0, now.Location())
+			if when.Before(now) {
+				when = when.Add(24 * time.Hour)
+			}
+		}
+		return when, nil
+	}
+	return time.Time{}, fmt.Errorf("can't understand time: %s", s)
+}
+
+func draftfromrow(row RowLike) (*Draft, error) {
+	d := new(Draft)
+	var dt, publish, form string
+	err := row.Scan(&d.ID, &d.UserID, &dt, &publish, &form)
+	if err != nil {
+		return nil, err
+	}
+	d.Date, _ = time.Parse(dbtimeformat, dt)
+	if publish != "" {
+		d.Publish, _ = time.Parse(dbtimeformat, publish)
+	}
+	err = decodeJson(form, &d.Form)
+	return d, err
+}
+
+func getdraft(userid UserID, draftid int64) *Draft {
+	d, err := draftfromrow(stmtGetDraft.QueryRow(draftid, userid))
+	if err != nil {
+		return nil
+	}
+	return d
+}
+
+func draftsfromrows(rows *sql.Rows, err error) []*Draft {
+	if err != nil {
+		elog.Printf("error querying drafts: %s", err)
+		return nil
+	}
+	defer rows.Close()
+	var drafts []*Draft
+	for rows.Next() {
+		d, err := draftfromrow(rows)
+		if err != nil {
+			elog.Printf("error scanning draft: %s", err)
+			continue
+		}
+		drafts = append(drafts, d)
+	}
+	return drafts
+}
+
+func getdrafts(userid UserID) []*Draft {
+	rows, err := stmtGetDrafts.Query(userid)
+	return draftsfromrows(rows, err)
+}
+
+func deletedraft(userid UserID, draftid int64) {
+	_, err := stmtDeleteDraft.Exec(draftid, userid)
+	if err != nil {
+		elog.Printf("error deleting draft: %s", err)
+	}
+}
+
+// attachments are saved now, so the draft only needs their ids
+func savedraft(w http.ResponseWriter, r *http.Request) {
+	u := login.GetUserInfo(r)
+	userid := UserID(u.UserID)
+	var publish string
+	if s := strings.TrimSpace(r.FormValue("publishat")); s != "" {
+		when, err := parsepublishtime(s)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		publish = when.UTC().Format(dbtimeformat)
+	}
+	form := make(url.Values)
+	for k, v := range r.PostForm {
+		form[k] = v
+	}
+	for _, k := range draftskipfields {
+		delete(form, k)
+	}
+	if form.Get("donkxid") == "" {
+		donks, err := submitdonk(w, r)
+		if err != nil && err != http.ErrMissingFile {
+			return
+		}
+		var xids []string
+		for _, d := range donks {
+			xids = append(xids, fmt.Sprintf("%s:%d", d.XID, d.FileID))
+		}
+		if len(xids) > 0 {
+			form.Set("donkxid", strings.Join(xids, ","))
+		}
+	}
+	draftid, _ := strconv.ParseInt(r.FormValue("draftid"), 10, 0)
+	if publish != "" {
+		// nobody will be around to fix it later
+		user, _ := getUserBio(u.Username)
+		fileids := formfileids(form)
+		desc := strings.TrimSpace(form.Get("donkdesc"))
+		if desc != "" && len(fileids) == 1 {
+			stmtSetPendingFileDesc.Exec(desc, fileids[0])
+		}
+		var donks []*Donk
+		for _, fileid := range fileids {
+			donks = append(donks, &Donk{FileID: fileid})
+		}
+		if msg, overridable := altproblem(user, donks); msg != "" {
+			if !overridable || form.Get("noalt") != "noalt" {
+				draftpage(w, r, form, draftid, r.FormValue("publishat"), msg, overridable)
+				return
+			}
+		}
+	}
+	j, err := encodeJson(form)
+	if err != nil {
+		elog.Printf("error encoding draft: %s", err)
+		return
+	}
+	dt := time.Now().UTC().Format(dbtimeformat)
+	if draftid != 0 {
+		_, err = stmtUpdateDraft.Exec(dt, publish, j, draftid, userid)
+	} else {
+		_, err = stmtSaveDraft.Exec(userid, dt, publish, j)
+	}
+	if err != nil {
+		elog.Printf("error saving draft: %s", err)
+		http.Error(w, "error saving draft", http.StatusInternalServerError)
+		return
+	}
+	if publish != "" {
+		pokedrafts()
+	}
+	http.Redirect(w, r, "/drafts", http.StatusSeeOther)
+}
+
+// the file ids from a form's donkxid
+func formfileids(form url.Values) []int64 {
+	var fileids []int64
+	for _, xid := range strings.Split(form.Get("donkxid"), ",") {
+		if p := strings.Split(xid, ":"); len(p) > 1 {
+			fileid, _ := strconv.ParseInt(p[1], 10, 0)
+			fileids = append(fileids, fileid)
+		}
+	}
+	return fileids
+}
+
+// attachments saved with drafts shouldn't get cleaned up
+func draftfiles() map[int64]bool {
+	files := make(map[int64]bool)
+	rows, err := opendatabase().Query("select draftid, userid, dt, publish, form from drafts")
+	for _, d := range draftsfromrows(rows, err) {
+		for _, fileid := range formfileids(d.Form) {
+			files[fileid] = true
+		}
+	}
+	return files
+}
+
+func showdrafts(w http.ResponseWriter, r *http.Request) {
+	u := login.GetUserInfo(r)
+	templinfo := getInfo(r)
+	templinfo["Drafts"] = getdrafts(UserID(u.UserID))
+	templinfo["DraftCSRF"] = login.GetCSRF("draft", r)
+	err := readviews.Execute(w, "drafts.html", templinfo)
+	if err != nil {
+		elog.Print(err)
+	}
+}
+
+func editdraftpage(w http.ResponseWriter, r *http.Request) {
+	u := login.GetUserInfo(r)
+	draftid, _ := strconv.ParseInt(r.FormValue("draftid"), 10, 0)
+	d := getdraft(UserID(u.UserID), draftid)
+	if d == nil {
+		http.NotFound(w, r)
+		return
+	}
+	var publishat string
+	if !d.Publish.IsZero() {
+		publishat = d.Publish.Local().Format("2006-01-02 15:04")
+	}
+	draftpage(w, r, d.Form, d.ID, publishat, "edit draft", false)
+}
+
+// the compose form, filled in from a saved one
+func draftpage(w http.ResponseWriter, r *http.Request, form url.Values, draftid int64, publishat string, servermsg string, noalt bool) {
+	u := login.GetUserInfo(r)
+	templinfo := getInfo(r)
+	templinfo["HonkCSRF"] = login.GetCSRF("honkhonk", r)
+	templinfo["MapLink"] = getmaplink(u)
+	if draftid != 0 {
+		templinfo["DraftID"] = draftid
+	}
+	templinfo["PublishAt"] = publishat
+	templinfo["InReplyTo"] = form.Get("rid")
+	templinfo["UpdateXID"] = form.Get("updatexid")
+	templinfo["Noise"] = form.Get("noise")
+	templinfo["Onties"] = form.Get("onties")
+	templinfo["SeeAlso"] = form.Get("seealso")
+	templinfo["Link"] = form.Get("link")
+	templinfo["LegalName"] = form.Get("legalname")
+	templinfo["SavedFile"] = form.Get("donkxid")
+	templinfo["DonkDesc"] = form.Get("donkdesc")
+	templinfo["Private"] = form.Get("privacy") == "on"
+	templinfo["Circle"] = form.Get("circle")
+	if start := form.Get("timestart"); start != "" {
+		templinfo["ShowTime"] = " "
+		templinfo["StartTime"] = start
+		templinfo["Duration"] = form.Get("timeend")
+	}
+	if form.Get("placename") != "" || form.Get("placeurl") != "" {
+		p := new(Place)
+		p.Name = form.Get("placename")
+		p.Url = form.Get("placeurl")
+		p.Latitude, _ = strconv.ParseFloat(form.Get("placelat"), 64)
+		p.Longitude, _ = strconv.ParseFloat(form.Get("placelong"), 64)
+		templinfo["SavedPlace"] = p
+	}
+	templinfo["ServerMessage"] = servermsg
+	templinfo["NoAlt"] = noalt
+	templinfo["IsPreview"] = true
+	err := readviews.Execute(w, "honkpage.html", templinfo)
+	if err != nil {
+		elog.Print(err)
+	}
+}
+
+func draftaction(w http.ResponseWriter, r *http.Request) {
+	u := login.GetUserInfo(r)
+	userid := UserID(u.UserID)
+	draftid, _ := strconv.ParseInt(r.FormValue("draftid"), 10, 0)
+	switch r.FormValue("action") {
+	case "delete":
+		deletedraft(userid, draftid)
+	case "publish":
+		d := getdraft(userid, draftid)
+		if d != nil {
+			publishdraft(d)
+		}
+	case "unschedule":
+		_, err := stmtScheduleDraft.Exec("", draftid, userid)
+		if err != nil {
+			elog.Printf("error unscheduling draft: %s", err)
+		}
+	}
+	http.Redirect(w, r, "/drafts", http.StatusSeeOther)
+}
+
+// build the honk from the saved form, as if just posted.
+// the user is told when that doesn't work out.
+func publishdraft(d *Draft) bool {
+	user, ok := somenumberedusers.Get(d.UserID)
+	if !ok || suspended(user) {
+		return false
+	}
+	honk, _, err := composehonk(user, d.Form, nil)
+	if err == nil {
+		if msg, overridable := altproblem(user, honk.Donks); msg != "" {
+			if !overridable || d.Form.Get("noalt") != "noalt" {
+				err = errors.New(msg)
+			}
+		}
+	}
+	if err == nil {
+		err = posthonk(user, honk)
+	}
+	if err != nil {
+		// keep it around for another look
+		ilog.Printf("unable to publish draft %d for %s: %s", d.ID, user.Name, err)
+		_, err2 := stmtScheduleDraft.Exec("", d.ID, d.UserID)
+		if err2 != nil {
+			elog.Printf("error unscheduling draft: %s", err2)
+		}
+		draftnotice(user, d, err.Error())
+		return false
+	}
+	ilog.Printf("published draft %d for %s", d.ID, user.Name)
+	deletedraft(d.UserID, d.ID)
+	return true
+}
+
+// every failure gets its own notice, so no notify
+func draftnotice(user *WhatAbout, d *Draft, reason string) {
+	xid := fmt.Sprintf("/editdraft?draftid=%d", d.ID)
+	dt := time.Now().UTC().Format(dbtimeformat)
+	_, err := stmtSaveNotice.Exec(user.ID, noticeDraft, user.URL, xid, reason, dt)
+	if err != nil {
+		elog.Printf("error saving notice: %s", err)
+		return
+	}
+	if !noticemuted(user, noticeDraft) {
+		noticeplusone(user.ID)
+	}
+}
+
+func draftsman() {
+	workinprogress++
+	sleeper := time.NewTimer(5 * time.Second)
+	for {
+		select {
+		case <-draftchan:
+			if !sleeper.Stop() {
+				<-sleeper.C
+			}
+		case <-sleeper.C:
+		case <-endoftheworld:
+			readyalready <- true
+			return
+		}
+
+		now := time.Now()
+		nexttime := now.Add(1 * time.Hour)
+		for _, d := range draftsfromrows(stmtScheduledDrafts.Query()) {
+			if !d.Publish.After(now) {
+				publishdraft(d)
+			} else if d.Publish.Before(nexttime) {
+				nexttime = d.Publish
+			}
+		}
+		sleeper.Reset(time.Until(nexttime) + time.Second)
+	}
+}
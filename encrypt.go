@@ -96,6 +96,8 @@ func newChatKeys() (boxPubKey, boxSecKey) {
 	return boxPubKey{pub}, boxSecKey{sec}
 }
 
+var chatkeyInvalidator gencache.Invalidator[string]
+
 var chatkeys = gencache.New(gencache.Options[string, boxPubKey]{Fill: func(xonker string) (boxPubKey, bool) {
 	data := getxonker(xonker, chatKeyProp)
 	if data == "" {
@@ -125,7 +127,7 @@ var chatkeys = gencache.New(gencache.Options[string, boxPubKey]{Fill: func(xonke
 		ilog.Printf("error decoding %s pubkey: %s", xonker, err)
 	}
 	return pubkey, true
-}, Limit: 512})
+}, Limit: 512, Invalidator: &chatkeyInvalidator})
 
 func getchatkey(xonker string) (boxPubKey, bool) {
 	pubkey, _ := chatkeys.Get(xonker)
@@ -0,0 +1,189 @@
+//
+// Copyright (c) 2024 Ted Unangst <tedu@tedunangst.com>
+//
+// Permission to use, copy, modify, and distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ontologies are kept as #tags, however they were typed
+func expirekeeps(s string) []string {
+	var onts []string
+	for _, o := range strings.Fields(strings.ToLower(s)) {
+		o = strings.TrimLeft(o, "#")
+		if o != "" {
+			onts = append(onts, "#"+o)
+		}
+	}
+	return onts
+}
+
+func sparehonk(user *WhatAbout, honk *ActivityPubActivity) bool {
+	if honk.IsSaved() {
+		return true
+	}
+	for _, o := range honk.Onts {
+		o = strings.ToLower(o)
+		for _, k := range user.Options.ExpireKeep {
+			if o == k {
+				return true
+			}
+		}
+	}
+	if user.Options.ExpireKeepReacted && len(honk.Badonks) > 0 {
+		return true
+	}
+	if user.Options.ExpireKeepReplied {
+		var count int64
+		err := stmtCountReplies.QueryRow(honk.XID).Scan(&count)
+		if err != nil || count > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// our own honks past their time, minus the ones to keep
+func expiringhonks(user *WhatAbout) []*ActivityPubActivity {
+	if user.Options.ExpireDays <= 0 {
+		return nil
+	}
+	cutoff := time.Now().UTC().Add(-time.Duration(user.Options.ExpireDays) * 24 * time.Hour)
+	dt := cutoff.Format(dbtimeformat)
+	var expired []*ActivityPubActivity
+	var after int64
+	for {
+		rows, err := stmtExpiringHonks.Query(after, user.ID, dt, 500)
+		honks := getsomehonks(rows, err)
+		if len(honks) == 0 {
+			break
+		}
+		for _, h := range honks {
+			if !sparehonk(user, h) {
+				expired = append(expired, h)
+			}
+		}
+		after = honks[len(honks)-1].ID
+	}
+	return expired
+}
+
+// the hourly run takes this many at a time, the rest wait for the next
+const expirebatch = 200
+
+// the deletes are gathered up by inbox, so each remote server gets
+// one delivery for the whole lot instead of one per honk.
+func expirehonks(user *WhatAbout, limit int) (int, *sync.WaitGroup) {
+	honks := expiringhonks(user)
+	if limit > 0 && len(honks) > limit {
+		honks = honks[:limit]
+	}
+	var dubs []string
+	for _, h := range getdubs(user.ID) {
+		dubs = append(dubs, h.XID)
+	}
+	now := time.Now().UTC()
+	boxes := make(map[string][][]byte)
+	for _, h := range honks {
+		deletehonk(h.ID)
+		_, err := stmtSaveZonker.Exec(user.ID, h.XID, "zonk")
+		if err != nil {
+			elog.Printf("error saving zonker: %s", err)
+		}
+		if h.Whofore != WhoPublic && h.Whofore != WhoPrivate {
+			continue
+		}
+		zonk := &ActivityPubActivity{
+			Honker:   user.URL,
+			What:     "zonk",
+			XID:      h.XID,
+			Date:     now,
+			Audience: stringArrayTrimUntilDupe(h.Audience),
+		}
+		zonk.Public = loudandproud(zonk.Audience)
+		j, _ := jonkjonk(user, zonk)
+		j["@context"] = itiswhatitis
+		msg := j.ToBytes()
+		aud := zonk.Audience
+		if zonk.Public || isAdvancedPrivateHonkActually(user, zonk) {
+			aud = append(aud, dubs...)
+		}
+		for rcpt := range boxuprcpts(user, aud, zonk.Public) {
+			boxes[rcpt] = append(boxes[rcpt], msg)
+		}
+	}
+	// failures land in the doovers and get retried as usual
+	var wg sync.WaitGroup
+	for rcpt, msgs := range boxes {
+		var d Doover
+		d.Userid = user.ID
+		d.Rcpt = rcpt
+		d.Msgs = msgs
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			deliveration(d)
+		}()
+	}
+	if len(honks) > 0 {
+		ilog.Printf("expired %d honks for %s, deletes to %d inboxes", len(honks), user.Name, len(boxes))
+	}
+	return len(honks), &wg
+}
+
+func expirator() {
+	workinprogress++
+	sleeper := time.NewTimer(10 * time.Minute)
+	for {
+		select {
+		case <-sleeper.C:
+		case <-endoftheworld:
+			readyalready <- true
+			return
+		}
+		for _, user := range getallusers() {
+			if user.Options.ExpireDays > 0 && !suspended(user) {
+				expirehonks(user, expirebatch)
+			}
+		}
+		sleeper.Reset(1 * time.Hour)
+	}
+}
+
+func cliexpire(args []string) {
+	if len(args) < 2 {
+		errx("usage: honk expire username [dryrun]")
+	}
+	user, err := getUserBio(args[1])
+	if err != nil {
+		errx("user %s not found", args[1])
+	}
+	if user.Options.ExpireDays <= 0 {
+		errx("user %s has no expiry set", user.Name)
+	}
+	if len(args) > 2 && args[2] == "dryrun" {
+		for _, h := range expiringhonks(user) {
+			fmt.Printf("%s %s\n", h.Date.Format(time.DateOnly), h.XID)
+		}
+		return
+	}
+	count, wg := expirehonks(user, 0)
+	fmt.Printf("expired %d honks, sending deletes\n", count)
+	wg.Wait()
+}
@@ -25,6 +25,7 @@ import (
 	"os"
 	"path"
 	"strings"
+	"sync"
 )
 
 var storeTheFilesInTheFileSystem = true
@@ -62,10 +63,14 @@ func savefiledata(xid string, data []byte) error {
 	}
 }
 
+var filehashmtx sync.Mutex
+
 func savefileandxid(name string, desc string, url string, media string, local bool, data []byte, meta *DonkMeta) (int64, string, error) {
 	var xid string
 	if local {
 		hash := hashfiledata(data)
+		filehashmtx.Lock()
+		defer filehashmtx.Unlock()
 		row := stmtCheckFileHash.QueryRow(hash)
 		err := row.Scan(&xid)
 		if err == sql.ErrNoRows {
@@ -81,8 +86,10 @@ func savefileandxid(name string, desc string, url string, media string, local bo
 				xid += ".pdf"
 			case "text/plain":
 				xid += ".txt"
-			case "video/mp4":
-				xid += ".mp4"
+			default:
+				if f, ok := avformats[media]; ok {
+					xid += "." + f.ext
+				}
 			}
 			err = savefiledata(xid, data)
 			if err == nil {
@@ -179,7 +186,43 @@ func loadblobdata(xid string) ([]byte, func(), error) {
 	return data, func() { rows.Close() }, err
 }
 
+// dedupfiles leaves the old xid behind with the same hash.
+// its data lives with one of the twins.
 func loaddata(xid string) ([]byte, func(), error) {
+	data, closer, err := loadstoreddata(xid)
+	if err == nil {
+		return data, closer, err
+	}
+	for _, twin := range filetwins(xid) {
+		data, closer, err2 := loadstoreddata(twin)
+		if err2 == nil {
+			return data, closer, err2
+		}
+	}
+	return data, closer, err
+}
+
+func filetwins(xid string) []string {
+	rows, err := stmtFileTwins.Query(xid, xid)
+	if err != nil {
+		elog.Printf("error finding file twins: %s", err)
+		return nil
+	}
+	defer rows.Close()
+	var twins []string
+	for rows.Next() {
+		var twin string
+		err = rows.Scan(&twin)
+		if err != nil {
+			elog.Printf("error scanning file twin: %s", err)
+			continue
+		}
+		twins = append(twins, twin)
+	}
+	return twins
+}
+
+func loadstoreddata(xid string) ([]byte, func(), error) {
 	if storeTheFilesInTheFileSystem {
 		data, closer, err := loadfiledata(xid)
 		if err == nil {
@@ -265,7 +308,8 @@ func cleanupfiles() {
 	}
 
 	db := opendatabase()
-	rows, err = db.Query("select xid from filemeta")
+	// a file is in use if anything with the same hash is
+	rows, err = db.Query("select xid from filemeta union select xid from filehashes where hash in (select hash from filehashes join filemeta using (xid))")
 	checkErr(err)
 	for rows.Next() {
 		xid := scan()
@@ -276,6 +320,8 @@ func cleanupfiles() {
 
 	tx, err := db.Begin()
 	checkErr(err)
+	_, err = tx.Exec("delete from filehashes where hash not in (select hash from filehashes join filemeta using (xid))")
+	checkErr(err)
 	for xid := range fsFiles {
 		_, err = tx.Exec("delete from filehashes where xid = ?", xid)
 		checkErr(err)
@@ -308,6 +354,94 @@ func cleanupfiles() {
 	closedatabases()
 }
 
+func dedupfiles() {
+	db := opendatabase()
+
+	// hash anything saved before filehashes was a thing
+	type unhashed struct {
+		xid, media string
+	}
+	var missing []unhashed
+	rows, err := db.Query("select xid, max(media) from filemeta where local = 1 and xid not in (select xid from filehashes) group by xid")
+	checkErr(err)
+	for rows.Next() {
+		var u unhashed
+		err = rows.Scan(&u.xid, &u.media)
+		checkErr(err)
+		missing = append(missing, u)
+	}
+	rows.Close()
+	for _, u := range missing {
+		data, closer, err := loaddata(u.xid)
+		if err != nil {
+			ilog.Printf("unable to load %s: %s", u.xid, err)
+			continue
+		}
+		hash := hashfiledata(data)
+		closer()
+		sqlMustQuery(db, "insert into filehashes (xid, hash, media) values (?, ?, ?)", u.xid, hash, u.media)
+	}
+
+	// the first copy with data is kept. the others stay in filehashes,
+	// so their xids still resolve, but lose their data.
+	var dups []string
+	rows, err = db.Query("select hash, xid from filehashes order by hash, rowid")
+	checkErr(err)
+	type hashed struct {
+		hash, xid string
+	}
+	var all []hashed
+	for rows.Next() {
+		var h hashed
+		err = rows.Scan(&h.hash, &h.xid)
+		checkErr(err)
+		all = append(all, h)
+	}
+	rows.Close()
+	var reclaimed int64
+	var lasthash string
+	for _, h := range all {
+		size, ok := storedsize(h.xid)
+		if !ok {
+			continue
+		}
+		if h.hash != lasthash {
+			lasthash = h.hash
+			continue
+		}
+		dups = append(dups, h.xid)
+		reclaimed += size
+	}
+
+	for _, xid := range dups {
+		if storeTheFilesInTheFileSystem {
+			os.Remove(filepath(xid))
+		}
+		if g_blobdb != nil {
+			_, err = g_blobdb.Exec("delete from filedata where xid = ?", xid)
+			checkErr(err)
+		}
+	}
+	fmt.Printf("merged %d duplicate files, reclaimed %d bytes\n", len(dups), reclaimed)
+	closedatabases()
+}
+
+func storedsize(xid string) (int64, bool) {
+	if storeTheFilesInTheFileSystem {
+		if fi, err := os.Stat(filepath(xid)); err == nil {
+			return fi.Size(), true
+		}
+	}
+	if g_blobdb != nil {
+		var size int64
+		row := g_blobdb.QueryRow("select length(content) from filedata where xid = ?", xid)
+		if row.Scan(&size) == nil {
+			return size, true
+		}
+	}
+	return 0, false
+}
+
 func extractblobs() {
 	if !storeTheFilesInTheFileSystem {
 		elog.Fatal("can only extract blobs when using filestore")
@@ -0,0 +1,375 @@
+//
+// Copyright (c) 2024 Ted Unangst <tedu@tedunangst.com>
+//
+// Permission to use, copy, modify, and distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"humungus.tedunangst.com/r/webs/login"
+)
+
+// more than this and somebody is sharing something else
+const maxSharedFilters = 1000
+
+type FilterList struct {
+	URL   string
+	Count int
+}
+
+// every filter, expired or not, as saved
+func loadfilters(userid UserID) []*Filter {
+	rows, err := stmtGetFilters.Query(userid)
+	if err != nil {
+		elog.Printf("error querying filters: %s", err)
+		return nil
+	}
+	defer rows.Close()
+	var filts []*Filter
+	for rows.Next() {
+		filt := new(Filter)
+		var j, lasthit string
+		err = rows.Scan(&filt.ID, &j, &filt.Hits, &lasthit)
+		if err == nil {
+			err = decodeJson(j, filt)
+		}
+		if lasthit != "" {
+			filt.LastHit, _ = time.Parse(dbtimeformat, lasthit)
+		}
+		if err != nil {
+			elog.Printf("error scanning filter: %s", err)
+			continue
+		}
+		filts = append(filts, filt)
+	}
+	return filts
+}
+
+// the parts worth passing around
+func (filt *Filter) shareable() *Filter {
+	f := new(Filter)
+	f.Name = filt.Name
+	f.Date = filt.Date
+	f.Actor = filt.Actor
+	f.IncludeAudience = filt.IncludeAudience
+	f.OnlyUnknowns = filt.OnlyUnknowns
+	f.Text = filt.Text
+	f.IsReply = filt.IsReply
+	f.IsAnnounce = filt.IsAnnounce
+	f.AnnounceOf = filt.AnnounceOf
+	f.NoAltText = filt.NoAltText
+	f.Language = filt.Language
+	f.Media = filt.Media
+	f.MinMentions = filt.MinMentions
+	f.MinHashtags = filt.MinHashtags
+	f.LinkOnly = filt.LinkOnly
+	f.AccountAge = filt.AccountAge
+	f.FewerFollowers = filt.FewerFollowers
+	f.Reject = filt.Reject
+	f.SkipMedia = filt.SkipMedia
+	f.Hide = filt.Hide
+	f.Collapse = filt.Collapse
+	f.Rewrite = filt.Rewrite
+	f.Replace = filt.Replace
+	f.Expiration = filt.Expiration
+	f.Notes = filt.Notes
+	return f
+}
+
+func (filt *Filter) blank() bool {
+	return filt.Actor == "" && filt.Text == "" && !filt.IsAnnounce && !filt.NoAltText && !filt.traits()
+}
+
+// same name, or no names and the same matches
+func (filt *Filter) samefilter(other *Filter) bool {
+	if filt.Name != "" || other.Name != "" {
+		return filt.Name == other.Name
+	}
+	return filt.Actor == other.Actor && filt.IncludeAudience == other.IncludeAudience &&
+		filt.OnlyUnknowns == other.OnlyUnknowns && filt.Text == other.Text &&
+		filt.IsReply == other.IsReply && filt.IsAnnounce == other.IsAnnounce &&
+		filt.AnnounceOf == other.AnnounceOf && filt.NoAltText == other.NoAltText &&
+		filt.Language == other.Language && filt.Media == other.Media &&
+		filt.MinMentions == other.MinMentions && filt.MinHashtags == other.MinHashtags &&
+		filt.LinkOnly == other.LinkOnly && filt.AccountAge == other.AccountAge &&
+		filt.FewerFollowers == other.FewerFollowers
+}
+
+func savefilter(userid UserID, filt *Filter) error {
+	j, err := encodeJson(filt)
+	if err == nil {
+		if filt.ID != 0 {
+			_, err = stmtUpdateFilter.Exec(j, filt.ID, userid)
+		} else {
+			_, err = stmtSaveFilter.Exec(userid, j)
+		}
+	}
+	return err
+}
+
+func readfilters(r io.Reader) ([]*Filter, error) {
+	data, err := io.ReadAll(io.LimitReader(r, maxFetchSize))
+	if err != nil {
+		return nil, err
+	}
+	var filts []*Filter
+	err = decodeJson(string(data), &filts)
+	if err != nil {
+		return nil, fmt.Errorf("can't read filters: %w", err)
+	}
+	if len(filts) > maxSharedFilters {
+		return nil, fmt.Errorf("too many filters: %d", len(filts))
+	}
+	var good []*Filter
+	for _, filt := range filts {
+		if filt == nil {
+			continue
+		}
+		filt = filt.shareable()
+		if filt.blank() || filt.compile() != nil {
+			ilog.Printf("skipping unusable shared filter: %s", filt.Name)
+			continue
+		}
+		if filt.Date.IsZero() {
+			filt.Date = time.Now().UTC()
+		}
+		good = append(good, filt)
+	}
+	return good, nil
+}
+
+func exportfilters(w http.ResponseWriter, r *http.Request) {
+	u := login.GetUserInfo(r)
+	filts := []*Filter{}
+	for _, filt := range loadfilters(UserID(u.UserID)) {
+		if filt.Source == "" {
+			filts = append(filts, filt.shareable())
+		}
+	}
+	j, err := encodeJson(filts)
+	if err != nil {
+		elog.Printf("error exporting filters: %s", err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"filters-%s.json\"", u.Username))
+	io.WriteString(w, j)
+}
+
+// conflicts are skipped, replaced, or kept alongside
+func importfilters(userid UserID, filts []*Filter, conflict string) (added, replaced, skipped int) {
+	existing := loadfilters(userid)
+	for _, filt := range filts {
+		var dupe *Filter
+		for _, e := range existing {
+			if e.Source == "" && e.samefilter(filt) {
+				dupe = e
+				break
+			}
+		}
+		if dupe != nil {
+			switch conflict {
+			case "replace":
+				filt.ID = dupe.ID
+			case "keep":
+			default:
+				skipped++
+				continue
+			}
+		}
+		err := savefilter(userid, filt)
+		if err != nil {
+			elog.Printf("error importing filter: %s", err)
+			continue
+		}
+		if filt.ID != 0 {
+			replaced++
+		} else {
+			added++
+			existing = append(existing, filt)
+		}
+	}
+	filtInvalidator.Clear(userid)
+	return
+}
+
+func importfiltershandler(w http.ResponseWriter, r *http.Request) {
+	u := login.GetUserInfo(r)
+	file, _, err := r.FormFile("filters")
+	if err != nil {
+		http.Error(w, "no file", http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+	filts, err := readfilters(file)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	added, replaced, skipped := importfilters(UserID(u.UserID), filts, r.FormValue("conflict"))
+	ilog.Printf("imported filters for %s: %d added %d replaced %d skipped", u.Username, added, replaced, skipped)
+	http.Redirect(w, r, "/hfcs", http.StatusSeeOther)
+}
+
+// anybody can subscribe to anything, so same rules as cards
+func fetchfilterlist(url string) ([]*Filter, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), slowTimeout*time.Second)
+	defer cancel()
+	resp, err := cardget(ctx, url, "application/json")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	return readfilters(resp.Body)
+}
+
+// filters from a list replace whatever the list had before.
+// they can't be changed here, only unsubscribed.
+func refreshfilterlist(userid UserID, url string) error {
+	filts, err := fetchfilterlist(url)
+	if err != nil {
+		return err
+	}
+	old := make(map[*Filter]bool)
+	for _, filt := range loadfilters(userid) {
+		if filt.Source == url {
+			old[filt] = true
+		}
+	}
+	for _, filt := range filts {
+		filt.Source = url
+		for o := range old {
+			if o.samefilter(filt) {
+				filt.ID = o.ID
+				delete(old, o)
+				break
+			}
+		}
+		err = savefilter(userid, filt)
+		if err != nil {
+			elog.Printf("error saving listed filter: %s", err)
+		}
+	}
+	for o := range old {
+		stmtDeleteFilter.Exec(userid, o.ID)
+	}
+	filtInvalidator.Clear(userid)
+	dlog.Printf("refreshed filter list %s: %d filters", url, len(filts))
+	return nil
+}
+
+func dropfilterlist(userid UserID, url string) {
+	for _, filt := range loadfilters(userid) {
+		if filt.Source == url {
+			stmtDeleteFilter.Exec(userid, filt.ID)
+		}
+	}
+	filtInvalidator.Clear(userid)
+}
+
+func filterlists(user *WhatAbout) []FilterList {
+	counts := make(map[string]int)
+	for _, filt := range loadfilters(user.ID) {
+		if filt.Source != "" {
+			counts[filt.Source]++
+		}
+	}
+	var lists []FilterList
+	for _, url := range user.Options.FilterLists {
+		lists = append(lists, FilterList{URL: url, Count: counts[url]})
+	}
+	return lists
+}
+
+func filterlisthandler(w http.ResponseWriter, r *http.Request) {
+	u := login.GetUserInfo(r)
+	user, _ := getUserBio(u.Username)
+	url := strings.TrimSpace(r.FormValue("url"))
+	if !strings.HasPrefix(url, "https://") {
+		http.Error(w, "filter lists need an https url", http.StatusBadRequest)
+		return
+	}
+	options := user.Options
+	var lists []string
+	for _, l := range options.FilterLists {
+		if l != url {
+			lists = append(lists, l)
+		}
+	}
+	switch r.FormValue("action") {
+	case "subscribe":
+		err := refreshfilterlist(user.ID, url)
+		if err != nil {
+			// don't say why, it only helps poking around
+			ilog.Printf("error getting filter list %s for %s: %s", url, user.Name, err)
+			http.Error(w, "can't get filter list", http.StatusBadRequest)
+			return
+		}
+		lists = append(lists, url)
+	case "unsubscribe":
+		dropfilterlist(user.ID, url)
+	case "refresh":
+		if len(lists) == len(options.FilterLists) {
+			http.Error(w, "not subscribed to that list", http.StatusBadRequest)
+			return
+		}
+		err := refreshfilterlist(user.ID, url)
+		if err != nil {
+			ilog.Printf("error getting filter list %s for %s: %s", url, user.Name, err)
+			http.Error(w, "can't get filter list", http.StatusBadRequest)
+			return
+		}
+		lists = options.FilterLists
+	default:
+		http.Error(w, "unknown action", http.StatusBadRequest)
+		return
+	}
+	options.FilterLists = lists
+	err := saveoptions(user, options)
+	if err != nil {
+		elog.Printf("error saving filter lists: %s", err)
+	}
+	http.Redirect(w, r, "/hfcs", http.StatusSeeOther)
+}
+
+func filterlistfetcher() {
+	workinprogress++
+	sleeper := time.NewTimer(15 * time.Minute)
+	for {
+		select {
+		case <-sleeper.C:
+		case <-endoftheworld:
+			readyalready <- true
+			return
+		}
+		for _, user := range getallusers() {
+			if suspended(user) {
+				continue
+			}
+			for _, url := range user.Options.FilterLists {
+				err := refreshfilterlist(user.ID, url)
+				if err != nil {
+					ilog.Printf("error refreshing filter list %s for %s: %s", url, user.Name, err)
+				}
+			}
+		}
+		sleeper.Reset(6 * time.Hour)
+	}
+}
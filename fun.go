@@ -54,15 +54,17 @@ func init() {
 	allowedclasses["dl"] = true
 }
 
-var relingo = make(map[string]string)
+var lingos = []string{"honked", "bonked", "honked back", "qonked", "evented"}
 
-func loadLingo() {
-	for _, l := range []string{"honked", "bonked", "honked back", "qonked", "evented"} {
+func loadLingo() map[string]string {
+	lingo := make(map[string]string)
+	for _, l := range lingos {
 		v := l
 		k := "lingo-" + strings.ReplaceAll(l, " ", "")
 		getConfigValue(k, &v)
-		relingo[l] = v
+		lingo[l] = v
 	}
+	return lingo
 }
 
 func reverbolate(userid UserID, honks []*ActivityPubActivity) {
@@ -207,17 +209,27 @@ func reverbolate(userid UserID, honks []*ActivityPubActivity) {
 			}
 		}
 		h.Donks = h.Donks[:j]
+		// avatars are fetched by /a and card images are saved with
+		// the card, so attachments are all that's left
+		if user != nil && user.Options.ProxyMedia {
+			for _, d := range h.Donks {
+				if !d.Local && !d.External && d.URL != "" && proxyable(d.Media) {
+					d.URL = proxyURL(d.URL, "big")
+				}
+			}
+		}
 	}
 	handlers.Wait()
 
 	unsee(honks, userid)
 
+	lingo := live().Lingo
 	for _, h := range honks {
 		renderflags(h)
 
 		h.HTPrecis = template.HTML(h.Precis)
 		h.HTML = template.HTML(h.Noise)
-		if redo := relingo[h.What]; redo != "" {
+		if redo := lingo[h.What]; redo != "" {
 			h.What = redo
 		}
 	}
@@ -16,16 +16,20 @@
 package main
 
 import (
+	"fmt"
 	"net/http"
 	"regexp"
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 	"unicode"
 
+	"golang.org/x/net/html"
 	"humungus.tedunangst.com/r/webs/cache"
 	"humungus.tedunangst.com/r/webs/gencache"
+	"humungus.tedunangst.com/r/webs/htfilter"
 	"humungus.tedunangst.com/r/webs/login"
 )
 
@@ -42,6 +46,14 @@ type Filter struct {
 	IsReply         bool   `json:",omitempty"`
 	IsAnnounce      bool   `json:",omitempty"`
 	AnnounceOf      string `json:",omitempty"`
+	NoAltText       bool   `json:",omitempty"`
+	Language        string `json:",omitempty"`
+	Media           string `json:",omitempty"`
+	MinMentions     int    `json:",omitempty"`
+	MinHashtags     int    `json:",omitempty"`
+	LinkOnly        bool   `json:",omitempty"`
+	AccountAge      int    `json:",omitempty"` // days
+	FewerFollowers  int64  `json:",omitempty"`
 	Reject          bool   `json:",omitempty"`
 	SkipMedia       bool   `json:",omitempty"`
 	Hide            bool   `json:",omitempty"`
@@ -51,6 +63,10 @@ type Filter struct {
 	Replace         string `json:",omitempty"`
 	Expiration      time.Time
 	Notes           string
+	Source          string    `json:",omitempty"`
+	Hits            int64     `json:"-"`
+	LastHit         time.Time `json:"-"`
+	userid          UserID
 }
 
 type filtType uint
@@ -99,12 +115,15 @@ func filtcachefiller(userid UserID) (afiltermap, bool) {
 	filtmap := make(afiltermap)
 	for rows.Next() {
 		filt := new(Filter)
-		var j string
+		var j, lasthit string
 		var filterid int64
-		err = rows.Scan(&filterid, &j)
+		err = rows.Scan(&filterid, &j, &filt.Hits, &lasthit)
 		if err == nil {
 			err = decodeJson(j, filt)
 		}
+		if lasthit != "" {
+			filt.LastHit, _ = time.Parse(dbtimeformat, lasthit)
+		}
 		if err != nil {
 			elog.Printf("error scanning filter: %s", err)
 			continue
@@ -118,39 +137,13 @@ func filtcachefiller(userid UserID) (afiltermap, bool) {
 				expflush = filt.Expiration
 			}
 		}
-		if t := filt.Text; t != "" && t != "." {
-			wordfront := unicode.IsLetter(rune(t[0]))
-			wordtail := unicode.IsLetter(rune(t[len(t)-1]))
-			t = "(?i:" + t + ")"
-			if wordfront {
-				t = "\\b" + t
-			}
-			if wordtail {
-				t = t + "\\b"
-			}
-			filt.re_text, err = regexp.Compile(t)
-			if err != nil {
-				elog.Printf("error compiling filter text: %s", err)
-				continue
-			}
-		}
-		if t := filt.Rewrite; t != "" {
-			wordfront := unicode.IsLetter(rune(t[0]))
-			wordtail := unicode.IsLetter(rune(t[len(t)-1]))
-			t = "(?i:" + t + ")"
-			if wordfront {
-				t = "\\b" + t
-			}
-			if wordtail {
-				t = t + "\\b"
-			}
-			filt.re_rewrite, err = regexp.Compile(t)
-			if err != nil {
-				elog.Printf("error compiling filter rewrite: %s", err)
-				continue
-			}
+		err = filt.compile()
+		if err != nil {
+			elog.Printf("error compiling filter: %s", err)
+			continue
 		}
 		filt.ID = filterid
+		filt.userid = userid
 		if filt.Reject {
 			filt.Actions = append(filt.Actions, filtReject)
 			filtmap[filtReject] = append(filtmap[filtReject], filt)
@@ -184,6 +177,36 @@ func filtcachefiller(userid UserID) (afiltermap, bool) {
 	return filtmap, true
 }
 
+func filtregexp(t string) (*regexp.Regexp, error) {
+	wordfront := unicode.IsLetter(rune(t[0]))
+	wordtail := unicode.IsLetter(rune(t[len(t)-1]))
+	t = "(?i:" + t + ")"
+	if wordfront {
+		t = "\\b" + t
+	}
+	if wordtail {
+		t = t + "\\b"
+	}
+	return regexp.Compile(t)
+}
+
+func (filt *Filter) compile() error {
+	var err error
+	if t := filt.Text; t != "" && t != "." {
+		filt.re_text, err = filtregexp(t)
+		if err != nil {
+			return fmt.Errorf("text: %w", err)
+		}
+	}
+	if t := filt.Rewrite; t != "" {
+		filt.re_rewrite, err = filtregexp(t)
+		if err != nil {
+			return fmt.Errorf("rewrite: %w", err)
+		}
+	}
+	return nil
+}
+
 func filtcacheclear(userid UserID, dur time.Duration) {
 	dlog.Printf("clearing filters in %s", dur.String())
 	time.Sleep(dur + time.Second)
@@ -206,7 +229,7 @@ var rejectcache = gencache.New(gencache.Options[UserID, arejectmap]{Fill: func(u
 	m := make(arejectmap)
 	filts := getfilters(userid, filtReject)
 	for _, f := range filts {
-		if f.Text != "" {
+		if f.Text != "" || ((f.NoAltText || f.traits()) && f.Actor == "") {
 			key := rejectAnyKey
 			m[key] = append(m[key], f)
 			continue
@@ -234,15 +257,17 @@ func rejectorigin(userid UserID, origin string, isannounce bool) bool {
 	}
 	filts := rejectfilters(userid, origin)
 	for _, f := range filts {
-		if f.OnlyUnknowns {
+		if f.OnlyUnknowns || f.NoAltText || f.traits() {
 			continue
 		}
 		if isannounce && f.IsAnnounce {
 			if f.AnnounceOf == origin {
+				filthit(f)
 				return true
 			}
 		}
 		if f.Actor == origin {
+			filthit(f)
 			return true
 		}
 	}
@@ -255,10 +280,11 @@ func rejectactor(userid UserID, actor string) bool {
 		if f.IsAnnounce || f.IsReply {
 			continue
 		}
-		if f.Text != "" {
+		if f.Text != "" || f.NoAltText || f.traits() {
 			continue
 		}
 		ilog.Printf("rejecting actor: %s", actor)
+		filthit(f)
 		return true
 	}
 	origin := originate(actor)
@@ -267,18 +293,20 @@ func rejectactor(userid UserID, actor string) bool {
 	}
 	filts = rejectfilters(userid, origin)
 	for _, f := range filts {
-		if f.IsAnnounce {
+		if f.IsAnnounce || f.NoAltText || f.traits() {
 			continue
 		}
 		if f.Actor == origin {
 			if f.OnlyUnknowns {
 				if unknownActor(userid, actor) {
 					ilog.Printf("rejecting unknown actor: %s", actor)
+					filthit(f)
 					return true
 				}
 				continue
 			}
 			ilog.Printf("rejecting actor: %s", actor)
+			filthit(f)
 			return true
 		}
 	}
@@ -376,6 +404,67 @@ func matchfilterX(h *ActivityPubActivity, f *Filter) string {
 			rv = m
 		}
 	}
+	if match && f.NoAltText {
+		match = false
+		if h.Whofore != WhoPublic && h.Whofore != WhoPrivate && lacksalt(h.Donks) {
+			match = true
+			rv += " no alt text"
+		}
+	}
+	if match && f.Language != "" {
+		match = false
+		if matchlanguage(f.Language, h.Language) {
+			match = true
+			rv += " language " + h.Language
+		}
+	}
+	if match && f.Media != "" {
+		match = false
+		if hasmedia(h.Donks, f.Media) {
+			match = true
+			rv += " media " + f.Media
+		}
+	}
+	if match && f.MinMentions > 0 {
+		match = false
+		if len(h.Mentions) >= f.MinMentions {
+			match = true
+			rv += fmt.Sprintf(" %d mentions", len(h.Mentions))
+		}
+	}
+	if match && f.MinHashtags > 0 {
+		match = false
+		if len(h.Onts) >= f.MinHashtags {
+			match = true
+			rv += fmt.Sprintf(" %d hashtags", len(h.Onts))
+		}
+	}
+	if match && f.LinkOnly {
+		match = false
+		if linkonly(h.Noise) {
+			match = true
+			rv += " link only"
+		}
+	}
+	if match && f.AccountAge > 0 {
+		match = false
+		info := getactorinfo(honkauthor(h))
+		if info != nil && !info.Published.IsZero() {
+			age := time.Since(info.Published)
+			if age < time.Duration(f.AccountAge)*24*time.Hour {
+				match = true
+				rv += fmt.Sprintf(" account %d days old", int(age.Hours()/24))
+			}
+		}
+	}
+	if match && f.FewerFollowers > 0 {
+		match = false
+		info := getactorinfo(honkauthor(h))
+		if info != nil && info.Followers >= 0 && info.Followers < f.FewerFollowers {
+			match = true
+			rv += fmt.Sprintf(" %d followers", info.Followers)
+		}
+	}
 	if match && f.Text == "." {
 		match = false
 		if h.Precis != "" {
@@ -389,6 +478,104 @@ func matchfilterX(h *ActivityPubActivity, f *Filter) string {
 	return ""
 }
 
+// conditions about the honk or its author, not where it's from
+func (filt *Filter) traits() bool {
+	return filt.Language != "" || filt.Media != "" || filt.MinMentions > 0 ||
+		filt.MinHashtags > 0 || filt.LinkOnly || filt.AccountAge > 0 || filt.FewerFollowers > 0
+}
+
+func honkauthor(h *ActivityPubActivity) string {
+	if h.Oonker != "" {
+		return h.Oonker
+	}
+	return h.Honker
+}
+
+// en matches en-US too
+func matchlanguage(langs string, lang string) bool {
+	if lang == "" {
+		return false
+	}
+	lang = strings.ToLower(lang)
+	base, _, _ := strings.Cut(lang, "-")
+	for _, l := range strings.FieldsFunc(strings.ToLower(langs), func(r rune) bool { return r == ' ' || r == ',' }) {
+		if l == lang || l == base {
+			return true
+		}
+	}
+	return false
+}
+
+// any, or a media type prefix like image or video
+func hasmedia(donks []*Donk, kind string) bool {
+	for _, d := range donks {
+		if kind == "any" || strings.HasPrefix(d.Media, kind) {
+			return true
+		}
+	}
+	return false
+}
+
+// nothing but links, not counting mentions and hashtags
+func linkonly(noise string) bool {
+	root, err := html.Parse(strings.NewReader(noise))
+	if err != nil {
+		return false
+	}
+	var htf htfilter.Filter
+	links := 0
+	other := false
+	var walk func(node *html.Node)
+	walk = func(node *html.Node) {
+		switch {
+		case node.Type == html.ElementNode && node.Data == "a":
+			t := strings.TrimSpace(htf.NodeText(node))
+			if !strings.HasPrefix(t, "@") && !strings.HasPrefix(t, "#") {
+				links++
+			}
+			return
+		case node.Type == html.TextNode:
+			if strings.TrimSpace(node.Data) != "" {
+				other = true
+			}
+		}
+		for c := node.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(root)
+	return links > 0 && !other
+}
+
+// look up whatever filters need to know before judging
+func prepfilters(xonk *ActivityPubActivity) {
+	for _, f := range getfilters(xonk.UserID, filtAny) {
+		if f.AccountAge > 0 || f.FewerFollowers > 0 {
+			fetchactorinfo(honkauthor(xonk))
+			return
+		}
+	}
+}
+
+// remote descriptions arrive as either name or summary,
+// but ours start out as the file name.
+func undescribed(d *Donk) bool {
+	if !strings.HasPrefix(d.Media, "image/") {
+		return false
+	}
+	desc := strings.TrimSpace(d.Desc)
+	return desc == "" || (!d.External && desc == d.Name)
+}
+
+func lacksalt(donks []*Donk) bool {
+	for _, d := range donks {
+		if undescribed(d) {
+			return true
+		}
+	}
+	return false
+}
+
 func rejectxonk(xonk *ActivityPubActivity) bool {
 	m, _ := rejectcache.Get(xonk.UserID)
 	filts := m[rejectAnyKey]
@@ -403,6 +590,10 @@ func rejectxonk(xonk *ActivityPubActivity) bool {
 	for _, f := range filts {
 		if cause := matchfilterX(xonk, f); cause != "" {
 			ilog.Printf("rejecting %s because %s", xonk.XID, cause)
+			filthit(f)
+			if user, ok := somenumberedusers.Get(xonk.UserID); ok {
+				spamsample(user, xonk, true)
+			}
 			return true
 		}
 	}
@@ -424,6 +615,12 @@ func unsee(honks []*ActivityPubActivity, userid UserID) {
 		colfilts := getfilters(userid, filtCollapse)
 		rwfilts := getfilters(userid, filtRewrite)
 		for _, h := range honks {
+			if h.IsSpammy() {
+				if h.Precis == "" {
+					h.Precis = "possible spam"
+				}
+				h.Open = ""
+			}
 			for _, f := range colfilts {
 				if bad := matchfilterX(h, f); bad != "" {
 					if h.Precis == "" {
@@ -499,6 +696,16 @@ func osmosis(honks []*ActivityPubActivity, userid UserID, withfilt bool) []*Acti
 	untagged.Unlock()
 	honks = honks[0:j]
 	reversehonks(honks)
+	if quarantined(userid) {
+		j = 0
+		for _, h := range honks {
+			if !h.IsSpammy() {
+				honks[j] = h
+				j++
+			}
+		}
+		honks = honks[0:j]
+	}
 	if !withfilt {
 		return honks
 	}
@@ -523,6 +730,12 @@ func savehfcs(w http.ResponseWriter, r *http.Request) {
 	itsok := r.FormValue("itsok")
 	if itsok == "iforgiveyou" {
 		hfcsid, _ := strconv.ParseInt(r.FormValue("hfcsid"), 10, 0)
+		for _, filt := range getfilters(userid, filtAny) {
+			if filt.ID == hfcsid && filt.Source != "" {
+				http.Error(w, "that filter belongs to a list", http.StatusBadRequest)
+				return
+			}
+		}
 		_, err := stmtDeleteFilter.Exec(userid, hfcsid)
 		if err != nil {
 			elog.Printf("error deleting filter: %s", err)
@@ -532,6 +745,34 @@ func savehfcs(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	filt := filterfromform(r)
+	if filt.blank() {
+		ilog.Printf("blank filter")
+		http.Error(w, "can't save a blank filter", http.StatusInternalServerError)
+		return
+	}
+	if r.FormValue("test") != "" {
+		previewfilter(w, r, filt)
+		return
+	}
+	if err := filt.compile(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	j, err := encodeJson(filt)
+	if err == nil {
+		_, err = stmtSaveFilter.Exec(userid, j)
+	}
+	if err != nil {
+		elog.Printf("error saving filter: %s", err)
+	}
+
+	filtInvalidator.Clear(userid)
+	http.Redirect(w, r, "/hfcs", http.StatusSeeOther)
+}
+
+func filterfromform(r *http.Request) *Filter {
 	filt := new(Filter)
 	filt.Name = strings.TrimSpace(r.FormValue("name"))
 	filt.Date = time.Now().UTC()
@@ -542,6 +783,14 @@ func savehfcs(w http.ResponseWriter, r *http.Request) {
 	filt.IsReply = r.FormValue("isreply") == "yes"
 	filt.IsAnnounce = r.FormValue("isannounce") == "yes"
 	filt.AnnounceOf = strings.TrimSpace(r.FormValue("announceof"))
+	filt.NoAltText = r.FormValue("noalttext") == "yes"
+	filt.Language = strings.TrimSpace(r.FormValue("filtlang"))
+	filt.Media = strings.TrimSpace(r.FormValue("filtmedia"))
+	filt.MinMentions, _ = strconv.Atoi(r.FormValue("minmentions"))
+	filt.MinHashtags, _ = strconv.Atoi(r.FormValue("minhashtags"))
+	filt.LinkOnly = r.FormValue("linkonly") == "yes"
+	filt.AccountAge, _ = strconv.Atoi(r.FormValue("accountage"))
+	filt.FewerFollowers, _ = strconv.ParseInt(r.FormValue("fewerfollowers"), 10, 0)
 	filt.Reject = r.FormValue("doreject") == "yes"
 	filt.SkipMedia = r.FormValue("doskipmedia") == "yes"
 	filt.Hide = r.FormValue("dohide") == "yes"
@@ -552,21 +801,126 @@ func savehfcs(w http.ResponseWriter, r *http.Request) {
 		filt.Expiration = time.Now().UTC().Add(dur)
 	}
 	filt.Notes = strings.TrimSpace(r.FormValue("filtnotes"))
+	return filt
+}
 
-	if filt.Actor == "" && filt.Text == "" && !filt.IsAnnounce {
-		ilog.Printf("blank filter")
-		http.Error(w, "can't save a blank filter", http.StatusInternalServerError)
+type FilterMatch struct {
+	Honk  *ActivityPubActivity
+	Cause string
+}
+
+// what a filter would have done to the recent past, without saving it
+func previewfilter(w http.ResponseWriter, r *http.Request, filt *Filter) {
+	u := login.GetUserInfo(r)
+	user, _ := getUserBio(u.Username)
+	userid := user.ID
+	err := filt.compile()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
+	honks := getsomehonks(stmtFilterTestHonks.Query(userid, user.URL, 500))
+	var matches []FilterMatch
+	for _, h := range honks {
+		if cause := matchfilterX(h, filt); cause != "" {
+			matches = append(matches, FilterMatch{Honk: h, Cause: cause})
+		}
+	}
+	templinfo := getInfo(r)
+	templinfo["Filters"] = getfilters(userid, filtAny)
+	templinfo["FilterCSRF"] = login.GetCSRF("filter", r)
+	templinfo["FilterLists"] = filterlists(user)
+	templinfo["Draft"] = filt
+	templinfo["DraftDuration"] = r.FormValue("filtduration")
+	templinfo["Tested"] = len(honks)
+	templinfo["Matches"] = matches
+	err = readviews.Execute(w, "hfcs.html", templinfo)
+	if err != nil {
+		elog.Print(err)
+	}
+}
 
-	j, err := encodeJson(filt)
-	if err == nil {
-		_, err = stmtSaveFilter.Exec(userid, j)
+type filthitcount struct {
+	userid UserID
+	count  int64
+	last   time.Time
+}
+
+// hits are tallied here and written out now and then
+var filthits = make(map[int64]*filthitcount)
+var filthitmtx sync.Mutex
+
+func filthit(f *Filter) {
+	if f.ID == 0 {
+		return
 	}
-	if err != nil {
-		elog.Printf("error saving filter: %s", err)
+	filthitmtx.Lock()
+	hit := filthits[f.ID]
+	if hit == nil {
+		hit = &filthitcount{userid: f.userid}
+		filthits[f.ID] = hit
 	}
+	hit.count++
+	hit.last = time.Now().UTC()
+	filthitmtx.Unlock()
+}
 
-	filtInvalidator.Clear(userid)
-	http.Redirect(w, r, "/hfcs", http.StatusSeeOther)
+func (f *Filter) HitCount() int64 {
+	filthitmtx.Lock()
+	defer filthitmtx.Unlock()
+	if hit := filthits[f.ID]; hit != nil {
+		return f.Hits + hit.count
+	}
+	return f.Hits
+}
+
+func (f *Filter) LastHitTime() time.Time {
+	filthitmtx.Lock()
+	defer filthitmtx.Unlock()
+	if hit := filthits[f.ID]; hit != nil {
+		return hit.last
+	}
+	return f.LastHit
+}
+
+// saved honks count once against every filter they match
+func countfilters(h *ActivityPubActivity) {
+	for _, f := range getfilters(h.UserID, filtAny) {
+		if matchfilter(h, f) {
+			filthit(f)
+		}
+	}
+}
+
+func flushfilthits() {
+	filthitmtx.Lock()
+	hits := filthits
+	filthits = make(map[int64]*filthitcount)
+	filthitmtx.Unlock()
+	users := make(map[UserID]bool)
+	for filterid, hit := range hits {
+		_, err := stmtFilterHits.Exec(hit.count, hit.last.Format(dbtimeformat), filterid, hit.userid)
+		if err != nil {
+			elog.Printf("error saving filter hits: %s", err)
+		}
+		users[hit.userid] = true
+	}
+	for userid := range users {
+		filtInvalidator.Clear(userid)
+	}
+}
+
+func filtcounter() {
+	workinprogress++
+	ticker := time.NewTicker(10 * time.Minute)
+	for {
+		select {
+		case <-ticker.C:
+			flushfilthits()
+		case <-endoftheworld:
+			flushfilthits()
+			readyalready <- true
+			return
+		}
+	}
 }
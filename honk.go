@@ -37,22 +37,44 @@ type WhatAbout struct {
 	SecKey     httpsig.PrivateKey
 	ChatPubKey boxPubKey
 	ChatSecKey boxSecKey
+	// kept to open messages sent to a previous key
+	OldChatSecKeys []boxSecKey
 }
 
 type UserOptions struct {
-	SkinnyCSS    bool   `json:",omitempty"`
-	OmitImages   bool   `json:",omitempty"`
-	MentionAll   bool   `json:",omitempty"`
-	InlineQuotes bool   `json:",omitempty"`
-	Avatar       string `json:",omitempty"`
-	Banner       string `json:",omitempty"`
-	MapLink      string `json:",omitempty"`
-	Reaction     string `json:",omitempty"`
-	MeCount      int64
-	ChatCount    int64
-	ChatPubKey   string
-	ChatSecKey   string
-	TOTP         string `json:",omitempty"`
+	SkinnyCSS         bool   `json:",omitempty"`
+	OmitImages        bool   `json:",omitempty"`
+	MentionAll        bool   `json:",omitempty"`
+	ManualFollows     bool   `json:",omitempty"`
+	InlineQuotes      bool   `json:",omitempty"`
+	Avatar            string `json:",omitempty"`
+	Banner            string `json:",omitempty"`
+	MapLink           string `json:",omitempty"`
+	Reaction          string `json:",omitempty"`
+	MeCount           int64
+	ChatCount         int64
+	NoticeCount       int64
+	ChatPubKey        string
+	ChatSecKey        string
+	OldChatSecKeys    []string `json:",omitempty"`
+	TOTP              string   `json:",omitempty"`
+	ProxyMedia        bool     `json:",omitempty"`
+	AltText           string   `json:",omitempty"`
+	Admin             bool     `json:"-"`
+	Pending           bool     `json:"-"`
+	Suspended         bool     `json:"-"`
+	InvitedBy         string   `json:",omitempty"`
+	Deleted           bool     `json:",omitempty"`
+	QuotaMegabytes    int64    `json:",omitempty"`
+	QuotaHonks        int64    `json:",omitempty"`
+	QuotaDeliveries   int64    `json:",omitempty"`
+	ExpireDays        int64    `json:",omitempty"`
+	ExpireKeep        []string `json:",omitempty"`
+	ExpireKeepReplied bool     `json:",omitempty"`
+	ExpireKeepReacted bool     `json:",omitempty"`
+	FilterLists       []string `json:",omitempty"`
+	SpamFilter        string   `json:",omitempty"`
+	MuteNotices       []string `json:",omitempty"`
 }
 
 type KeyInfo struct {
@@ -65,6 +87,13 @@ type UserID int64
 const serverUID UserID = -2
 const firstUserUID UserID = 1
 
+// users.userflags, kept out of the options so nothing else writes them
+const (
+	userIsAdmin     = 1
+	userIsPending   = 2
+	userIsSuspended = 4
+)
+
 type ActivityPubActivity struct {
 	ID        int64
 	UserID    UserID
@@ -102,6 +131,10 @@ type ActivityPubActivity struct {
 	SeeAlso   string
 	Onties    string
 	LegalName string
+	Card      *Card
+	Revisions []OldRevision
+	Language  string
+	Circle    string
 }
 
 type Whofore int
@@ -124,14 +157,19 @@ type Chonk struct {
 	Date   time.Time
 	Noise  string
 	Format string
+	NewKey string `json:",omitempty"` // opened with a key that isn't pinned
 	Donks  []*Donk
 	Handle string
 	HTML   template.HTML
 }
 
 type Chatter struct {
-	Target string
-	Chonks []*Chonk
+	Target   string
+	Name     string
+	Chonks   []*Chonk
+	Muted    bool
+	Archived bool
+	Keys     []ChatKeyInfo `json:",omitempty"`
 }
 
 type Mention struct {
@@ -176,6 +214,9 @@ func OntIsPresent(ont, noise string) bool {
 type OldRevision struct {
 	Precis string
 	Noise  string
+	Format string    `json:",omitempty"`
+	Date   time.Time // older revisions don't know
+	Alts   []string  // nor these, and then it's nil
 }
 
 const (
@@ -184,6 +225,7 @@ const (
 	flagIsSaved    = 4
 	flagIsUntagged = 8
 	flagIsReacted  = 16
+	flagIsSpammy   = 32
 	flagIsBSkyd    = 128
 )
 
@@ -211,6 +253,10 @@ func (honk *ActivityPubActivity) IsReacted() bool {
 	return honk.Flags&flagIsReacted != 0
 }
 
+func (honk *ActivityPubActivity) IsSpammy() bool {
+	return honk.Flags&flagIsSpammy != 0
+}
+
 func (honk *ActivityPubActivity) ShortXID() string {
 	return shortxid(honk.XID)
 }
@@ -227,9 +273,18 @@ type Donk struct {
 	Meta     DonkMeta
 }
 type DonkMeta struct {
-	Length int `json:",omitempty"`
-	Width  int `json:",omitempty"`
-	Height int `json:",omitempty"`
+	Length   int      `json:",omitempty"`
+	Width    int      `json:",omitempty"`
+	Height   int      `json:",omitempty"`
+	Duration Duration `json:",omitempty"`
+}
+
+func (d *Donk) IsAudio() bool {
+	return strings.HasPrefix(d.Media, "audio/")
+}
+
+func (d *Donk) IsVideo() bool {
+	return strings.HasPrefix(d.Media, "video/")
 }
 
 type Place struct {
@@ -21,6 +21,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"html"
+	"io"
 	"log"
 	"os"
 	"regexp"
@@ -577,12 +578,23 @@ func export(username, file string) {
 	if err != nil {
 		elog.Fatal(err)
 	}
+	err = exportarchive(user, fd)
+	if err != nil {
+		elog.Fatal(err)
+	}
+	err = fd.Close()
+	if err != nil {
+		elog.Fatal(err)
+	}
+}
+
+func exportarchive(user *WhatAbout, fd io.Writer) error {
 	zd := zip.NewWriter(fd)
 	donks := make(map[string]bool)
 	{
 		w, err := zd.Create("outbox.json")
 		if err != nil {
-			elog.Fatal("error creating outbox.json", err)
+			return fmt.Errorf("error creating outbox.json: %w", err)
 		}
 		var jonks []junk.Junk
 		rows, err := stmtUserHonks.Query(0, 3, user.Name, "0", 1234567)
@@ -614,7 +626,7 @@ func export(username, file string) {
 	{
 		w, err := zd.Create("inbox.json")
 		if err != nil {
-			elog.Fatal("error creating inbox.json", err)
+			return fmt.Errorf("error creating inbox.json: %w", err)
 		}
 		var jonks []junk.Junk
 		rows, err := stmtHonksForMe.Query(0, user.ID, "0", user.ID, 1234567)
@@ -654,8 +666,7 @@ func export(username, file string) {
 		w.Write(data)
 		closer()
 	}
-	zd.Close()
-	fd.Close()
+	return zd.Close()
 }
 
 func dumpthread(username, convoy string) {
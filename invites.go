@@ -0,0 +1,374 @@
+//
+// Copyright (c) 2024 Ted Unangst <tedu@tedunangst.com>
+//
+// Permission to use, copy, modify, and distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"humungus.tedunangst.com/r/webs/login"
+)
+
+type Invite struct {
+	ID       int64
+	UserID   UserID
+	Username string
+	Code     string
+	URL      string
+	Created  time.Time
+	Expiry   time.Time
+	MaxUses  int64
+	Uses     int64
+	Approval bool
+}
+
+func (inv *Invite) Valid() bool {
+	return inv.Uses < inv.MaxUses && time.Now().Before(inv.Expiry)
+}
+
+var errBadInvite = errors.New("invite is expired or used up")
+
+func isadmin(user *WhatAbout) bool {
+	return user != nil && user.Options.Admin && !suspended(user)
+}
+
+// pending users are waiting for approval, which is much the same,
+// and deleted users are never coming back
+func suspended(user *WhatAbout) bool {
+	return user.Options.Suspended || user.Options.Pending || user.Options.Deleted
+}
+
+func makeinvite(userid UserID, dur time.Duration, uses int64, approval bool) (*Invite, error) {
+	if uses < 1 {
+		uses = 1
+	}
+	if dur <= 0 {
+		dur = 7 * 24 * time.Hour
+	}
+	code := make18CharRandomString()
+	now := time.Now().UTC()
+	res, err := stmtSaveInvite.Exec(userid, code, now.Format(dbtimeformat),
+		now.Add(dur).Format(dbtimeformat), uses, approval)
+	if err != nil {
+		return nil, err
+	}
+	inv := &Invite{UserID: userid, Code: code, Created: now, Expiry: now.Add(dur),
+		MaxUses: uses, Approval: approval}
+	inv.ID, _ = res.LastInsertId()
+	inv.URL = serverURL("/signup?invite=%s", code)
+	return inv, nil
+}
+
+func invitefromrow(row RowLike) (*Invite, error) {
+	inv := new(Invite)
+	var created, expiry string
+	err := row.Scan(&inv.ID, &inv.UserID, &inv.Code, &created, &expiry, &inv.MaxUses, &inv.Uses, &inv.Approval)
+	if err != nil {
+		return nil, err
+	}
+	inv.Created, _ = time.Parse(dbtimeformat, created)
+	inv.Expiry, _ = time.Parse(dbtimeformat, expiry)
+	inv.URL = serverURL("/signup?invite=%s", inv.Code)
+	if user, ok := somenumberedusers.Get(inv.UserID); ok {
+		inv.Username = user.Name
+	}
+	return inv, nil
+}
+
+func getinvite(code string) *Invite {
+	inv, err := invitefromrow(stmtGetInvite.QueryRow(code))
+	if err != nil {
+		return nil
+	}
+	return inv
+}
+
+func getinvites() []*Invite {
+	rows, err := stmtGetInvites.Query()
+	if err != nil {
+		elog.Printf("error querying invites: %s", err)
+		return nil
+	}
+	defer rows.Close()
+	var invites []*Invite
+	for rows.Next() {
+		inv, err := invitefromrow(rows)
+		if err != nil {
+			elog.Printf("error scanning invite: %s", err)
+			continue
+		}
+		invites = append(invites, inv)
+	}
+	return invites
+}
+
+// claim a spot, give it back if signup fails
+func useinvite(inv *Invite) error {
+	res, err := stmtUseInvite.Exec(inv.ID, time.Now().UTC().Format(dbtimeformat))
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n != 1 {
+		return errBadInvite
+	}
+	return nil
+}
+
+func unuseinvite(inv *Invite) {
+	_, err := stmtUnuseInvite.Exec(inv.ID)
+	if err != nil {
+		elog.Printf("error returning invite: %s", err)
+	}
+}
+
+func getallusers() []*WhatAbout {
+	rows, err := opendatabase().Query("select userid from users where userid > 0")
+	if err != nil {
+		elog.Printf("error querying users: %s", err)
+		return nil
+	}
+	defer rows.Close()
+	var ids []UserID
+	for rows.Next() {
+		var userid UserID
+		err = rows.Scan(&userid)
+		if err != nil {
+			elog.Printf("error scanning user: %s", err)
+			continue
+		}
+		ids = append(ids, userid)
+	}
+	rows.Close()
+	var users []*WhatAbout
+	for _, userid := range ids {
+		if user, ok := somenumberedusers.Get(userid); ok {
+			users = append(users, user)
+		}
+	}
+	sort.Slice(users, func(i, j int) bool {
+		return users[i].Name < users[j].Name
+	})
+	return users
+}
+
+func signuppage(w http.ResponseWriter, r *http.Request) {
+	showsignup(w, r, "")
+}
+
+func showsignup(w http.ResponseWriter, r *http.Request, errmsg string) {
+	templinfo := getInfo(r)
+	code := r.FormValue("invite")
+	if inv := getinvite(code); inv != nil && inv.Valid() {
+		templinfo["Invite"] = inv
+	}
+	templinfo["SignupError"] = errmsg
+	templinfo["Username"] = r.FormValue("username")
+	err := readviews.Execute(w, "signup.html", templinfo)
+	if err != nil {
+		elog.Print(err)
+	}
+}
+
+func dosignup(w http.ResponseWriter, r *http.Request) {
+	inv := getinvite(r.FormValue("invite"))
+	if inv == nil || !inv.Valid() {
+		showsignup(w, r, errBadInvite.Error())
+		return
+	}
+	name := strings.TrimSpace(r.FormValue("username"))
+	pass := r.FormValue("password")
+	if pass != r.FormValue("password2") {
+		showsignup(w, r, "passwords don't match")
+		return
+	}
+	err := useinvite(inv)
+	if err != nil {
+		showsignup(w, r, err.Error())
+		return
+	}
+	var opts UserOptions
+	opts.InvitedBy = inv.Username
+	opts.Pending = inv.Approval
+	err = createuser(opendatabase(), name, pass, opts)
+	if err != nil {
+		unuseinvite(inv)
+		showsignup(w, r, err.Error())
+		return
+	}
+	ilog.Printf("new user %s invited by %s", name, inv.Username)
+	templinfo := getInfo(r)
+	if opts.Pending {
+		templinfo["ServerMessage"] = "welcome! your account is waiting for approval."
+	} else {
+		templinfo["ServerMessage"] = "welcome! you may now log in."
+	}
+	err = readviews.Execute(w, "msg.html", templinfo)
+	if err != nil {
+		elog.Print(err)
+	}
+}
+
+// actor, profile, inbox, and friends vanish while suspended or deleted
+func suspendguard(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/"+userSep+"/") {
+			name := mux.Vars(r)["name"]
+			if user, err := getUserBio(name); err == nil && suspended(user) {
+				if user.Options.Deleted {
+					http.Error(w, "gone", http.StatusGone)
+				} else if user.Options.Pending {
+					http.NotFound(w, r)
+				} else {
+					http.Error(w, "account suspended", http.StatusForbidden)
+				}
+				return
+			}
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
+
+func setuserflag(user *WhatAbout, flag int64, on bool) error {
+	var err error
+	if on {
+		_, err = stmtSetUserFlags.Exec(flag, user.ID)
+	} else {
+		_, err = stmtClearUserFlags.Exec(flag, user.ID)
+	}
+	somenamedusers.Clear(user.Name)
+	somenumberedusers.Clear(user.ID)
+	return err
+}
+
+func setuserstate(user *WhatAbout, what string) error {
+	var err error
+	switch what {
+	case "suspend":
+		err = setuserflag(user, userIsSuspended, true)
+	case "unsuspend":
+		err = setuserflag(user, userIsSuspended, false)
+	case "approve":
+		err = setuserflag(user, userIsPending, false)
+	case "makeadmin":
+		err = setuserflag(user, userIsAdmin, true)
+	case "unadmin":
+		err = setuserflag(user, userIsAdmin, false)
+	default:
+		return fmt.Errorf("unknown action: %s", what)
+	}
+	oldfingers.Flush()
+	authcache.Flush()
+	return err
+}
+
+func adminaction(w http.ResponseWriter, r *http.Request) {
+	u := login.GetUserInfo(r)
+	admin, _ := getUserBio(u.Username)
+	if !isadmin(admin) {
+		http.Error(w, "admins only", http.StatusForbidden)
+		return
+	}
+	var err error
+	what := r.FormValue("action")
+	switch what {
+	case "invite":
+		uses, _ := strconv.ParseInt(r.FormValue("uses"), 10, 0)
+		dur := parseDuration(r.FormValue("duration"))
+		approval := r.FormValue("approval") == "yes"
+		_, err = makeinvite(admin.ID, dur, uses, approval)
+	case "quota":
+		user, _ := getUserBio(r.FormValue("username"))
+		if user == nil {
+			http.Error(w, "not that user", http.StatusBadRequest)
+			return
+		}
+		options := user.Options
+		options.QuotaMegabytes, _ = strconv.ParseInt(r.FormValue("quotamegabytes"), 10, 0)
+		options.QuotaHonks, _ = strconv.ParseInt(r.FormValue("quotahonks"), 10, 0)
+		options.QuotaDeliveries, _ = strconv.ParseInt(r.FormValue("quotadeliveries"), 10, 0)
+		ilog.Printf("%s set quota for %s", admin.Name, user.Name)
+		err = saveoptions(user, options)
+	case "uninvite":
+		inviteid, _ := strconv.ParseInt(r.FormValue("inviteid"), 10, 0)
+		_, err = stmtDeleteInvite.Exec(inviteid)
+	case "suspend", "unsuspend", "approve", "reject", "makeadmin", "unadmin":
+		user, _ := getUserBio(r.FormValue("username"))
+		if user == nil || user.ID == admin.ID {
+			http.Error(w, "not that user", http.StatusBadRequest)
+			return
+		}
+		if what == "reject" {
+			if !user.Options.Pending {
+				http.Error(w, "only pending users can be rejected", http.StatusBadRequest)
+				return
+			}
+			ilog.Printf("%s rejected signup of %s", admin.Name, user.Name)
+			deluser(user.Name)
+			somenamedusers.Clear(user.Name)
+			somenumberedusers.Clear(user.ID)
+			break
+		}
+		ilog.Printf("%s did %s to %s", admin.Name, what, user.Name)
+		err = setuserstate(user, what)
+	default:
+		http.Error(w, "unknown action", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		elog.Printf("error doing admin %s: %s", what, err)
+	}
+	http.Redirect(w, r, "/admin", http.StatusSeeOther)
+}
+
+func cliinvite(args []string) {
+	var dur time.Duration
+	var uses int64
+	if len(args) > 1 {
+		dur = parseDuration(args[1])
+	}
+	if len(args) > 2 {
+		uses, _ = strconv.ParseInt(args[2], 10, 0)
+	}
+	inv, err := makeinvite(serverUID, dur, uses, false)
+	if err != nil {
+		errx("error making invite: %s", err)
+	}
+	fmt.Printf("%s\n", inv.URL)
+}
+
+func setadmin(username string, yesno string) {
+	user, err := getUserBio(username)
+	if err != nil {
+		errx("user %s not found", username)
+	}
+	switch yesno {
+	case "yes":
+		err = setuserflag(user, userIsAdmin, true)
+	case "no":
+		err = setuserflag(user, userIsAdmin, false)
+	default:
+		errx("say yes or no")
+	}
+	if err != nil {
+		errx("error saving user: %s", err)
+	}
+}
@@ -24,10 +24,12 @@ import (
 	golog "log"
 	"log/syslog"
 	notrand "math/rand"
+	"net"
 	"os"
 	"runtime/pprof"
 	"sort"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"humungus.tedunangst.com/r/webs/log"
@@ -45,9 +47,6 @@ var masqName string
 var dataDir = "."
 var viewDir = "."
 var iconName = "icon.png"
-var serverMsg template.HTML
-var aboutMsg template.HTML
-var loginMsg template.HTML
 var collectForwards = true
 
 func serverURL(u string, args ...interface{}) string {
@@ -99,6 +98,59 @@ func usage() {
 	fmt.Fprintf(out, "%s", strings.Join(msgs, ""))
 }
 
+// settings that can change while running.
+// always replaced whole, never modified, so readers need no lock.
+type LiveConfig struct {
+	ServerMsg       template.HTML
+	AboutMsg        template.HTML
+	LoginMsg        template.HTML
+	CardDenylist    string
+	TrustedProxies  []*net.IPNet
+	QuotaMegabytes  int64
+	QuotaHonks      int64
+	QuotaDeliveries int64
+	Lingo           map[string]string
+	AvatarColors    [4][4]byte
+}
+
+var liveconfig atomic.Pointer[LiveConfig]
+
+func live() *LiveConfig {
+	if lc := liveconfig.Load(); lc != nil {
+		return lc
+	}
+	return &LiveConfig{Lingo: make(map[string]string), AvatarColors: avatarcolors}
+}
+
+// starts from the defaults, so removed settings go back to them
+func reloadconfig() {
+	lc := new(LiveConfig)
+	getConfigValue("servermsg", &lc.ServerMsg)
+	getConfigValue("aboutmsg", &lc.AboutMsg)
+	getConfigValue("loginmsg", &lc.LoginMsg)
+	getConfigValue("carddenylist", &lc.CardDenylist)
+	proxies := "127.0.0.1 ::1"
+	getConfigValue("trustedproxies", &proxies)
+	lc.TrustedProxies = parseproxies(proxies)
+	getConfigValue("quotamegabytes", &lc.QuotaMegabytes)
+	getConfigValue("quotahonks", &lc.QuotaHonks)
+	getConfigValue("quotadeliveries", &lc.QuotaDeliveries)
+	lc.Lingo = loadLingo()
+	lc.AvatarColors = loadAvatarColors()
+	liveconfig.Store(lc)
+}
+
+// only read once, changing these needs a restart
+func startupconfig() {
+	getConfigValue("fasttimeout", &fastTimeout)
+	getConfigValue("slowtimeout", &slowTimeout)
+	days := int64(honkwindow / (24 * time.Hour))
+	getConfigValue("honkwindow", &days)
+	honkwindow = time.Duration(days) * 24 * time.Hour
+	getConfigValue("collectforwards", &collectForwards)
+	getConfigValue("cardtimeout", &cardTimeout)
+}
+
 func main() {
 	commands["help"] = cmd{
 		help: "you're looking at it",
@@ -159,9 +211,6 @@ func main() {
 		elog.Fatal("incorrect database version. run upgrade.")
 	}
 	getConfigValue("usefilestore", &storeTheFilesInTheFileSystem)
-	getConfigValue("servermsg", &serverMsg)
-	getConfigValue("aboutmsg", &aboutMsg)
-	getConfigValue("loginmsg", &loginMsg)
 	getConfigValue("servername", &serverName)
 	getConfigValue("masqname", &masqName)
 	if masqName == "" {
@@ -174,11 +223,8 @@ func main() {
 	if develMode {
 		disableTLSValidation()
 	}
-	getConfigValue("fasttimeout", &fastTimeout)
-	getConfigValue("slowtimeout", &slowTimeout)
-	getConfigValue("honkwindow", &honkwindow)
-	honkwindow *= 24 * time.Hour
-	getConfigValue("collectforwards", &collectForwards)
+	startupconfig()
+	reloadconfig()
 
 	prepareStatements(db)
 
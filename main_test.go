@@ -0,0 +1,42 @@
+package main
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+)
+
+func TestStartupConfig(t *testing.T) {
+	dataDir = t.TempDir()
+	db, err := sql.Open("sqlite3", dataDir+"/honk.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.Exec("create table config (key text, value text)")
+	db.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	alreadyopendb = nil
+	db = opendatabase()
+	defer func() {
+		db.Close()
+		alreadyopendb = nil
+	}()
+	saved := honkwindow
+	defer func() { honkwindow = saved }()
+
+	startupconfig()
+	if honkwindow < 89*24*time.Hour || honkwindow > 91*24*time.Hour {
+		t.Errorf("default honkwindow is %v", honkwindow)
+	}
+	startupconfig()
+	if honkwindow < 89*24*time.Hour || honkwindow > 91*24*time.Hour {
+		t.Errorf("honkwindow is %v after reading twice", honkwindow)
+	}
+	db.Exec("insert into config (key, value) values ('honkwindow', 7)")
+	startupconfig()
+	if honkwindow != 7*24*time.Hour {
+		t.Errorf("configured honkwindow is %v", honkwindow)
+	}
+}
@@ -0,0 +1,353 @@
+//
+// Copyright (c) 2024 Ted Unangst <tedu@tedunangst.com>
+//
+// Permission to use, copy, modify, and distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"strings"
+	"time"
+)
+
+type avformat struct {
+	ext   string
+	limit int
+}
+
+// what we're willing to keep, and how much of it
+var avformats = map[string]avformat{
+	"video/mp4":  {"mp4", 10000000},
+	"video/webm": {"webm", 10000000},
+	"video/ogg":  {"ogv", 10000000},
+	"audio/mp4":  {"m4a", 8000000},
+	"audio/mpeg": {"mp3", 8000000},
+	"audio/ogg":  {"ogg", 8000000},
+	"audio/webm": {"weba", 8000000},
+	"audio/flac": {"flac", 12000000},
+}
+
+func isav(media string) bool {
+	_, ok := avformats[media]
+	return ok
+}
+
+func avkind(media string) string {
+	if strings.HasPrefix(media, "audio/") {
+		return "Audio"
+	}
+	return "Video"
+}
+
+// identify audio and video by looking at the container
+func avsniff(data []byte) string {
+	switch {
+	case bytes.HasPrefix(data, []byte("fLaC")):
+		return "audio/flac"
+	case bytes.HasPrefix(data, []byte("OggS")):
+		head := data
+		if len(head) > 4096 {
+			head = head[:4096]
+		}
+		if bytes.Contains(head, []byte("\x80theora")) {
+			return "video/ogg"
+		}
+		return "audio/ogg"
+	case bytes.HasPrefix(data, []byte("\x1a\x45\xdf\xa3")):
+		var meta DonkMeta
+		if _, ok := mkvmeta(data, &meta); ok && meta.Width == 0 {
+			return "audio/webm"
+		}
+		return "video/webm"
+	case len(data) > 12 && string(data[4:8]) == "ftyp":
+		brand := string(data[8:12])
+		if brand == "M4A " || brand == "M4B " {
+			return "audio/mp4"
+		}
+		return "video/mp4"
+	case bytes.HasPrefix(data, []byte("ID3")):
+		return "audio/mpeg"
+	case len(data) > 4 && data[0] == 0xff && data[1]&0xe0 == 0xe0:
+		if _, ok := mp3frame(data); ok {
+			return "audio/mpeg"
+		}
+	}
+	return ""
+}
+
+// fill in duration and dimensions as best we can
+func avmeta(data []byte, media string, meta *DonkMeta) {
+	var secs float64
+	switch media {
+	case "video/mp4", "audio/mp4":
+		secs = mp4meta(data, meta)
+	case "video/webm", "audio/webm":
+		secs, _ = mkvmeta(data, meta)
+	case "audio/ogg", "video/ogg":
+		secs = oggmeta(data, meta)
+	case "audio/flac":
+		secs = flacmeta(data)
+	case "audio/mpeg":
+		secs = mp3meta(data)
+	}
+	if secs > 0 && secs < 1e6 {
+		d := time.Duration(secs * float64(time.Second))
+		meta.Duration = Duration(d.Round(time.Second))
+	}
+}
+
+func mp4boxes(data []byte, fn func(kind string, body []byte)) {
+	for len(data) >= 8 {
+		size := uint64(binary.BigEndian.Uint32(data))
+		kind := string(data[4:8])
+		hdr := uint64(8)
+		if size == 1 {
+			if len(data) < 16 {
+				return
+			}
+			size = binary.BigEndian.Uint64(data[8:])
+			hdr = 16
+		} else if size == 0 {
+			size = uint64(len(data))
+		}
+		if size < hdr || size > uint64(len(data)) {
+			return
+		}
+		fn(kind, data[hdr:size])
+		data = data[size:]
+	}
+}
+
+// real files nest a few levels at most
+const avmaxdepth = 16
+
+func mp4meta(data []byte, meta *DonkMeta) float64 {
+	var secs float64
+	depth := 0
+	var walk func(kind string, body []byte)
+	walk = func(kind string, body []byte) {
+		switch kind {
+		case "moov", "trak":
+			if depth >= avmaxdepth {
+				return
+			}
+			depth++
+			mp4boxes(body, walk)
+			depth--
+		case "mvhd":
+			if len(body) < 32 {
+				return
+			}
+			var scale, dur uint64
+			if body[0] == 1 {
+				scale = uint64(binary.BigEndian.Uint32(body[20:]))
+				dur = binary.BigEndian.Uint64(body[24:])
+			} else {
+				scale = uint64(binary.BigEndian.Uint32(body[12:]))
+				dur = uint64(binary.BigEndian.Uint32(body[16:]))
+			}
+			if scale > 0 {
+				secs = float64(dur) / float64(scale)
+			}
+		case "tkhd":
+			off := 76
+			if len(body) > 0 && body[0] == 1 {
+				off = 88
+			}
+			if len(body) < off+8 {
+				return
+			}
+			w := int(binary.BigEndian.Uint32(body[off:]) >> 16)
+			h := int(binary.BigEndian.Uint32(body[off+4:]) >> 16)
+			if w > meta.Width {
+				meta.Width = w
+				meta.Height = h
+			}
+		}
+	}
+	mp4boxes(data, walk)
+	return secs
+}
+
+func ebmlvint(data []byte, mask bool) (uint64, int) {
+	if len(data) == 0 || data[0] == 0 {
+		return 0, 0
+	}
+	n := 1
+	for b := data[0]; b&0x80 == 0; b <<= 1 {
+		n++
+	}
+	if n > 8 || n > len(data) {
+		return 0, 0
+	}
+	v := uint64(data[0])
+	if mask {
+		v &= 0xff >> n
+	}
+	allones := v == 0xff>>n
+	for i := 1; i < n; i++ {
+		v = v<<8 | uint64(data[i])
+		allones = allones && data[i] == 0xff
+	}
+	if mask && allones {
+		// unknown size, runs to the end
+		return math.MaxUint64, n
+	}
+	return v, n
+}
+
+func ebmluint(body []byte) uint64 {
+	var v uint64
+	for _, b := range body {
+		v = v<<8 | uint64(b)
+	}
+	return v
+}
+
+func mkvmeta(data []byte, meta *DonkMeta) (float64, bool) {
+	scale := uint64(1000000)
+	var dur float64
+	found := false
+	var walk func(data []byte, depth int)
+	walk = func(data []byte, depth int) {
+		if depth > avmaxdepth {
+			return
+		}
+		for len(data) > 0 {
+			id, n := ebmlvint(data, false)
+			if n == 0 {
+				return
+			}
+			size, m := ebmlvint(data[n:], true)
+			if m == 0 {
+				return
+			}
+			data = data[n+m:]
+			if size > uint64(len(data)) {
+				size = uint64(len(data))
+			}
+			body := data[:size]
+			data = data[size:]
+			switch id {
+			case 0x18538067, 0x1549a966, 0x1654ae6b, 0xae, 0xe0:
+				// segment, info, tracks, track entry, video
+				found = true
+				walk(body, depth+1)
+			case 0x2ad7b1:
+				scale = ebmluint(body)
+			case 0x4489:
+				if len(body) == 4 {
+					dur = float64(math.Float32frombits(binary.BigEndian.Uint32(body)))
+				} else if len(body) == 8 {
+					dur = math.Float64frombits(binary.BigEndian.Uint64(body))
+				}
+			case 0xb0:
+				meta.Width = int(ebmluint(body))
+			case 0xba:
+				meta.Height = int(ebmluint(body))
+			case 0x1f43b675:
+				// clusters are just data
+				return
+			}
+		}
+	}
+	walk(data, 0)
+	return dur * float64(scale) / 1e9, found
+}
+
+func oggmeta(data []byte, meta *DonkMeta) float64 {
+	if i := bytes.Index(data, []byte("\x80theora")); i >= 0 && len(data) > i+22 {
+		meta.Width = int(ebmluint(data[i+14 : i+17]))
+		meta.Height = int(ebmluint(data[i+17 : i+20]))
+	}
+	var rate uint64
+	skip := uint64(0)
+	if i := bytes.Index(data, []byte("\x01vorbis")); i >= 0 && len(data) > i+16 {
+		rate = uint64(binary.LittleEndian.Uint32(data[i+12:]))
+	} else if i := bytes.Index(data, []byte("OpusHead")); i >= 0 && len(data) > i+12 {
+		rate = 48000
+		skip = uint64(binary.LittleEndian.Uint16(data[i+10:]))
+	}
+	last := bytes.LastIndex(data, []byte("OggS"))
+	if rate == 0 || last < 0 || len(data) < last+14 {
+		return 0
+	}
+	granule := binary.LittleEndian.Uint64(data[last+6:])
+	if granule <= skip {
+		return 0
+	}
+	return float64(granule-skip) / float64(rate)
+}
+
+func flacmeta(data []byte) float64 {
+	// STREAMINFO is always the first metadata block
+	if len(data) < 26 {
+		return 0
+	}
+	info := data[8:]
+	rate := uint64(info[10])<<12 | uint64(info[11])<<4 | uint64(info[12])>>4
+	samples := uint64(info[13]&0x0f)<<32 | uint64(binary.BigEndian.Uint32(info[14:]))
+	if rate == 0 {
+		return 0
+	}
+	return float64(samples) / float64(rate)
+}
+
+var mp3bitrates = [2][16]int{
+	{0, 32, 40, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320, 0},
+	{0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160, 0},
+}
+
+// returns bitrate in kbps if it looks like a layer 3 frame
+func mp3frame(data []byte) (int, bool) {
+	if len(data) < 4 || data[0] != 0xff || data[1]&0xe0 != 0xe0 {
+		return 0, false
+	}
+	version := (data[1] >> 3) & 3
+	layer := (data[1] >> 1) & 3
+	if version == 1 || layer != 1 {
+		return 0, false
+	}
+	table := 0
+	if version != 3 {
+		table = 1
+	}
+	bitrate := mp3bitrates[table][data[2]>>4]
+	if bitrate == 0 {
+		return 0, false
+	}
+	return bitrate, true
+}
+
+func mp3meta(data []byte) float64 {
+	start := 0
+	if bytes.HasPrefix(data, []byte("ID3")) && len(data) > 10 {
+		sz := int(data[6]&0x7f)<<21 | int(data[7]&0x7f)<<14 | int(data[8]&0x7f)<<7 | int(data[9]&0x7f)
+		start = 10 + sz
+		if start >= len(data) {
+			return 0
+		}
+	}
+	for start < len(data)-4 && data[start] != 0xff {
+		start++
+	}
+	bitrate, ok := mp3frame(data[start:])
+	if !ok {
+		return 0
+	}
+	// assume constant bitrate
+	return float64(len(data)-start) * 8 / float64(bitrate*1000)
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+	"time"
+)
+
+func mp4box(kind string, body []byte) []byte {
+	box := make([]byte, 8, 8+len(body))
+	binary.BigEndian.PutUint32(box, uint32(8+len(body)))
+	copy(box[4:], kind)
+	return append(box, body...)
+}
+
+func TestAVMetaHostile(t *testing.T) {
+	nestedmkv := bytes.Repeat([]byte("\x18\x53\x80\x67\x01\xff\xff\xff\xff\xff\xff\xff"), 100000)
+	nestedmp4 := mp4box("mvhd", make([]byte, 32))
+	for i := 0; i < 1000; i++ {
+		nestedmp4 = mp4box("moov", nestedmp4)
+	}
+	tests := []struct {
+		name  string
+		media string
+		data  []byte
+	}{
+		{"id3 size past end", "audio/mpeg", []byte("ID3\x03\x00\x00\x00\x00\x7f\x7f\xff\xfb\x90\x00")},
+		{"id3 size at end", "audio/mpeg", []byte("ID3\x03\x00\x00\x00\x00\x00\x04\xff\xfb\x90\x00")},
+		{"id3 header only", "audio/mpeg", []byte("ID3\x03\x00\x00\x00\x00\x00\x00")},
+		{"short mp3", "audio/mpeg", []byte("\xff\xfb")},
+		{"empty mp3", "audio/mpeg", nil},
+		{"nested mkv", "video/webm", nestedmkv},
+		{"truncated mkv", "video/webm", []byte("\x1a\x45\xdf\xa3\x01")},
+		{"mkv huge vint", "video/webm", []byte("\x18\x53\x80\x67\x01\x00\x00\x00\x00\x00\x00\x40\x44\x89")},
+		{"nested mp4", "video/mp4", nestedmp4},
+		{"mp4 big size", "video/mp4", []byte("\x00\x00\x00\x01moov\xff\xff\xff\xff\xff\xff\xff\xff")},
+		{"mp4 short tkhd", "video/mp4", mp4box("moov", mp4box("trak", mp4box("tkhd", []byte{1})))},
+		{"truncated ogg", "audio/ogg", []byte("OggS\x01vorbis")},
+		{"theora at end", "video/ogg", []byte("OggS\x80theora\x00\x00")},
+		{"truncated flac", "audio/flac", []byte("fLaC\x00\x00\x00\x22")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var meta DonkMeta
+			avsniff(tt.data)
+			avmeta(tt.data, tt.media, &meta)
+			if meta.Duration != 0 {
+				t.Errorf("got duration %v from junk", meta.Duration)
+			}
+		})
+	}
+}
+
+func TestAVMeta(t *testing.T) {
+	mp3 := append([]byte("\xff\xfb\x90\x00"), make([]byte, 15996)...)
+	id3 := append([]byte("ID3\x03\x00\x00\x00\x00\x00\x04abcd"), mp3...)
+	mvhd := make([]byte, 32)
+	binary.BigEndian.PutUint32(mvhd[12:], 1000)
+	binary.BigEndian.PutUint32(mvhd[16:], 5000)
+	mp4 := mp4box("moov", mp4box("mvhd", mvhd))
+	tests := []struct {
+		name  string
+		media string
+		data  []byte
+		secs  int
+	}{
+		{"mp3", "audio/mpeg", mp3, 1},
+		{"mp3 with id3", "audio/mpeg", id3, 1},
+		{"mp4", "video/mp4", mp4, 5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var meta DonkMeta
+			avmeta(tt.data, tt.media, &meta)
+			if time.Duration(meta.Duration) != time.Duration(tt.secs)*time.Second {
+				t.Errorf("got %v want %ds", meta.Duration, tt.secs)
+			}
+		})
+	}
+}
@@ -0,0 +1,177 @@
+//
+// Copyright (c) 2024 Ted Unangst <tedu@tedunangst.com>
+//
+// Permission to use, copy, modify, and distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package main
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"humungus.tedunangst.com/r/webs/login"
+)
+
+const (
+	noticeFollow   = "follow"
+	noticeRequest  = "request"
+	noticeMention  = "mention"
+	noticeReply    = "reply"
+	noticeReaction = "reaction"
+	noticeBoost    = "boost"
+	noticeQuote    = "quote"
+	noticeDraft    = "draft"
+)
+
+var noticeTypes = []string{noticeFollow, noticeRequest, noticeMention, noticeReply, noticeReaction, noticeBoost, noticeQuote, noticeDraft}
+
+const noticepagesize = 100
+
+// somebody did something to us or one of our honks.
+// the xid is whatever they did it with or to.
+type Notice struct {
+	ID      int64
+	What    string
+	Who     string
+	Handle  string
+	XID     string
+	Content string
+	Date    time.Time
+	Seen    bool
+}
+
+func noticemuted(user *WhatAbout, what string) bool {
+	for _, m := range user.Options.MuteNotices {
+		if m == what {
+			return true
+		}
+	}
+	return false
+}
+
+func ourhonk(user *WhatAbout, xid string) bool {
+	return strings.HasPrefix(xid, user.URL+"/")
+}
+
+// muted notices are kept, just not counted
+func notify(user *WhatAbout, what string, who string, xid string, content string) {
+	if who == user.URL {
+		return
+	}
+	var noticeid int64
+	row := stmtFindNotice.QueryRow(user.ID, what, who, xid)
+	if row.Scan(&noticeid) == nil {
+		return
+	}
+	dt := time.Now().UTC().Format(dbtimeformat)
+	_, err := stmtSaveNotice.Exec(user.ID, what, who, xid, content, dt)
+	if err != nil {
+		elog.Printf("error saving notice: %s", err)
+		return
+	}
+	if !noticemuted(user, what) {
+		noticeplusone(user.ID)
+	}
+}
+
+// mentions, replies, and quotes in something just saved
+func honknotices(user *WhatAbout, xonk *ActivityPubActivity, quoteof string) {
+	if xonk.IsSpammy() && quarantined(user.ID) {
+		return
+	}
+	if quoteof != "" && ourhonk(user, quoteof) {
+		notify(user, noticeQuote, xonk.Honker, xonk.XID, "")
+	}
+	if xonk.RID != "" && ourhonk(user, xonk.RID) {
+		notify(user, noticeReply, xonk.Honker, xonk.XID, "")
+	} else if xonk.Whofore == WhoAtme {
+		notify(user, noticeMention, xonk.Honker, xonk.XID, "")
+	}
+}
+
+func getnotices(user *WhatAbout, after int64) []*Notice {
+	rows, err := stmtGetNotices.Query(user.ID, after, noticepagesize)
+	if err != nil {
+		elog.Printf("error querying notices: %s", err)
+		return nil
+	}
+	defer rows.Close()
+	var notices []*Notice
+	for rows.Next() {
+		n := new(Notice)
+		var dt string
+		var seen int
+		err = rows.Scan(&n.ID, &n.What, &n.Who, &n.XID, &n.Content, &dt, &seen)
+		if err != nil {
+			elog.Printf("error scanning notice: %s", err)
+			continue
+		}
+		if noticemuted(user, n.What) {
+			continue
+		}
+		n.Date, _ = time.Parse(dbtimeformat, dt)
+		n.Seen = seen != 0
+		n.Handle, _ = handles(n.Who)
+		notices = append(notices, n)
+	}
+	return notices
+}
+
+// everything up to and including upto has been seen
+func readnotices(user *WhatAbout, upto int64) {
+	_, err := stmtSeeNotices.Exec(user.ID, upto)
+	if err != nil {
+		elog.Printf("error seeing notices: %s", err)
+	}
+	noticenewnone(user.ID)
+}
+
+func shownotices(w http.ResponseWriter, r *http.Request) {
+	u := login.GetUserInfo(r)
+	user, _ := getUserBio(u.Username)
+	notices := getnotices(user, 0)
+	if len(notices) > 0 {
+		readnotices(user, notices[0].ID)
+	}
+	muted := make(map[string]bool)
+	for _, m := range user.Options.MuteNotices {
+		muted[m] = true
+	}
+	templinfo := getInfo(r)
+	templinfo["Notices"] = notices
+	templinfo["NoticeTypes"] = noticeTypes
+	templinfo["MutedNotices"] = muted
+	templinfo["NoticeCSRF"] = login.GetCSRF("notices", r)
+	err := readviews.Execute(w, "notices.html", templinfo)
+	if err != nil {
+		elog.Print(err)
+	}
+}
+
+func savenoticeprefs(w http.ResponseWriter, r *http.Request) {
+	u := login.GetUserInfo(r)
+	user, _ := getUserBio(u.Username)
+	options := user.Options
+	options.MuteNotices = nil
+	for _, what := range noticeTypes {
+		if r.FormValue("mute-"+what) != "" {
+			options.MuteNotices = append(options.MuteNotices, what)
+		}
+	}
+	err := saveoptions(user, options)
+	if err != nil {
+		elog.Printf("error saving notice prefs: %s", err)
+	}
+	http.Redirect(w, r, "/notices", http.StatusSeeOther)
+}
@@ -0,0 +1,803 @@
+//
+// Copyright (c) 2024 Ted Unangst <tedu@tedunangst.com>
+//
+// Permission to use, copy, modify, and distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+	"humungus.tedunangst.com/r/webs/junk"
+	"humungus.tedunangst.com/r/webs/login"
+)
+
+// webauthn, just enough of it.
+// we ask for no attestation and trust whatever key we're handed.
+
+type Passkey struct {
+	ID        int64
+	UserID    UserID
+	CredID    string
+	PubKey    []byte
+	SignCount uint32
+	Name      string
+	Created   time.Time
+	LastUsed  time.Time
+}
+
+var b64url = base64.RawURLEncoding
+
+var errBadPasskey = errors.New("bad passkey")
+
+type pkchallenge struct {
+	userid  UserID
+	expires time.Time
+}
+
+var pkchallenges = make(map[string]pkchallenge)
+var pkchallengemtx sync.Mutex
+
+// anybody can ask for a challenge, so only so many are kept
+const maxpkchallenges = 1000
+
+func newchallenge(userid UserID) string {
+	var buf [32]byte
+	rand.Read(buf[:])
+	ch := b64url.EncodeToString(buf[:])
+	now := time.Now()
+	pkchallengemtx.Lock()
+	defer pkchallengemtx.Unlock()
+	var oldest string
+	for k, c := range pkchallenges {
+		if c.expires.Before(now) {
+			delete(pkchallenges, k)
+		} else if oldest == "" || c.expires.Before(pkchallenges[oldest].expires) {
+			oldest = k
+		}
+	}
+	if len(pkchallenges) >= maxpkchallenges {
+		delete(pkchallenges, oldest)
+	}
+	pkchallenges[ch] = pkchallenge{userid: userid, expires: now.Add(5 * time.Minute)}
+	return ch
+}
+
+// login challenges are also kept in a cookie, so an assertion
+// only works in the browser that asked for it
+const pkcookie = "pkchallenge"
+
+func pkcookiechallenge(r *http.Request) string {
+	c, err := r.Cookie(pkcookie)
+	if err != nil {
+		return ""
+	}
+	return c.Value
+}
+
+// challenges are good for one use
+func takechallenge(ch string) (UserID, bool) {
+	pkchallengemtx.Lock()
+	defer pkchallengemtx.Unlock()
+	c, ok := pkchallenges[ch]
+	if !ok {
+		return 0, false
+	}
+	delete(pkchallenges, ch)
+	if c.expires.Before(time.Now()) {
+		return 0, false
+	}
+	return c.userid, true
+}
+
+func rpid() string {
+	return serverName
+}
+
+func rporigin(origin string) bool {
+	if origin == "https://"+serverName {
+		return true
+	}
+	return develMode && origin == "http://"+serverName
+}
+
+type cborReader struct {
+	data []byte
+}
+
+func (cr *cborReader) head() (byte, uint64, error) {
+	if len(cr.data) == 0 {
+		return 0, 0, io.ErrUnexpectedEOF
+	}
+	b := cr.data[0]
+	cr.data = cr.data[1:]
+	major := b >> 5
+	info := b & 0x1f
+	var n int
+	switch {
+	case info < 24:
+		return major, uint64(info), nil
+	case info == 24:
+		n = 1
+	case info == 25:
+		n = 2
+	case info == 26:
+		n = 4
+	case info == 27:
+		n = 8
+	default:
+		return 0, 0, errors.New("unsupported cbor length")
+	}
+	if len(cr.data) < n {
+		return 0, 0, io.ErrUnexpectedEOF
+	}
+	var v uint64
+	for _, c := range cr.data[:n] {
+		v = v<<8 | uint64(c)
+	}
+	cr.data = cr.data[n:]
+	return major, v, nil
+}
+
+// decodes into int64, []byte, string, []interface{}, map[interface{}]interface{}
+func (cr *cborReader) value(depth int) (interface{}, error) {
+	if depth > 16 {
+		return nil, errors.New("cbor too deep")
+	}
+	major, v, err := cr.head()
+	if err != nil {
+		return nil, err
+	}
+	switch major {
+	case 0:
+		return int64(v), nil
+	case 1:
+		return -1 - int64(v), nil
+	case 2, 3:
+		if v > uint64(len(cr.data)) {
+			return nil, io.ErrUnexpectedEOF
+		}
+		b := cr.data[:v]
+		cr.data = cr.data[v:]
+		if major == 3 {
+			return string(b), nil
+		}
+		return b, nil
+	case 4:
+		if v > uint64(len(cr.data)) {
+			return nil, io.ErrUnexpectedEOF
+		}
+		var arr []interface{}
+		for i := uint64(0); i < v; i++ {
+			e, err := cr.value(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			arr = append(arr, e)
+		}
+		return arr, nil
+	case 5:
+		if v > uint64(len(cr.data)) {
+			return nil, io.ErrUnexpectedEOF
+		}
+		m := make(map[interface{}]interface{})
+		for i := uint64(0); i < v; i++ {
+			k, err := cr.value(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			e, err := cr.value(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			switch k.(type) {
+			case int64, string:
+				m[k] = e
+			}
+		}
+		return m, nil
+	case 6:
+		return cr.value(depth + 1)
+	default:
+		return v, nil
+	}
+}
+
+func cbormap(data []byte) (map[interface{}]interface{}, []byte, error) {
+	cr := cborReader{data: data}
+	v, err := cr.value(0)
+	if err != nil {
+		return nil, nil, err
+	}
+	m, ok := v.(map[interface{}]interface{})
+	if !ok {
+		return nil, nil, errors.New("cbor not a map")
+	}
+	return m, cr.data, nil
+}
+
+func cosebytes(m map[interface{}]interface{}, k int64) []byte {
+	b, _ := m[k].([]byte)
+	return b
+}
+
+func coseint(m map[interface{}]interface{}, k int64) int64 {
+	i, _ := m[k].(int64)
+	return i
+}
+
+func cosekey(key []byte) (crypto.PublicKey, error) {
+	m, _, err := cbormap(key)
+	if err != nil {
+		return nil, err
+	}
+	kty := coseint(m, 1)
+	alg := coseint(m, 3)
+	switch {
+	case kty == 2 && alg == -7:
+		x, y := cosebytes(m, -2), cosebytes(m, -3)
+		if coseint(m, -1) != 1 || len(x) != 32 || len(y) != 32 {
+			return nil, errBadPasskey
+		}
+		pub := &ecdsa.PublicKey{
+			Curve: elliptic.P256(),
+			X:     new(big.Int).SetBytes(x),
+			Y:     new(big.Int).SetBytes(y),
+		}
+		if !pub.Curve.IsOnCurve(pub.X, pub.Y) {
+			return nil, errBadPasskey
+		}
+		return pub, nil
+	case kty == 1 && alg == -8:
+		x := cosebytes(m, -2)
+		if coseint(m, -1) != 6 || len(x) != ed25519.PublicKeySize {
+			return nil, errBadPasskey
+		}
+		return ed25519.PublicKey(x), nil
+	case kty == 3 && alg == -257:
+		n, e := cosebytes(m, -1), cosebytes(m, -2)
+		if len(n) < 256 || len(e) == 0 || len(e) > 4 {
+			return nil, errBadPasskey
+		}
+		pub := &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+		return pub, nil
+	}
+	return nil, fmt.Errorf("unsupported key type %d alg %d", kty, alg)
+}
+
+func coseverify(key []byte, data []byte, sig []byte) error {
+	pub, err := cosekey(key)
+	if err != nil {
+		return err
+	}
+	sum := sha256.Sum256(data)
+	switch pub := pub.(type) {
+	case *ecdsa.PublicKey:
+		if !ecdsa.VerifyASN1(pub, sum[:], sig) {
+			return errBadPasskey
+		}
+	case ed25519.PublicKey:
+		if !ed25519.Verify(pub, data, sig) {
+			return errBadPasskey
+		}
+	case *rsa.PublicKey:
+		return rsa.VerifyPKCS1v15(pub, crypto.SHA256, sum[:], sig)
+	}
+	return nil
+}
+
+type clientData struct {
+	Type      string `json:"type"`
+	Challenge string `json:"challenge"`
+	Origin    string `json:"origin"`
+}
+
+func checkclientdata(data []byte, kind string) (UserID, string, error) {
+	var cd clientData
+	err := json.Unmarshal(data, &cd)
+	if err != nil {
+		return 0, "", err
+	}
+	if cd.Type != kind {
+		return 0, "", fmt.Errorf("wrong client data type %s", cd.Type)
+	}
+	if !rporigin(cd.Origin) {
+		return 0, "", fmt.Errorf("wrong origin %s", cd.Origin)
+	}
+	userid, ok := takechallenge(cd.Challenge)
+	if !ok {
+		return 0, "", errors.New("unknown challenge")
+	}
+	return userid, cd.Challenge, nil
+}
+
+const (
+	authFlagUP = 0x01
+	authFlagUV = 0x04
+	authFlagAT = 0x40
+)
+
+type authData struct {
+	flags     byte
+	signcount uint32
+	credid    []byte
+	pubkey    []byte
+}
+
+func parseauthdata(data []byte) (*authData, error) {
+	if len(data) < 37 {
+		return nil, errBadPasskey
+	}
+	want := sha256.Sum256([]byte(rpid()))
+	if subtle.ConstantTimeCompare(data[:32], want[:]) != 1 {
+		return nil, errors.New("wrong rp id")
+	}
+	ad := new(authData)
+	ad.flags = data[32]
+	ad.signcount = binary.BigEndian.Uint32(data[33:])
+	if ad.flags&authFlagUP == 0 {
+		return nil, errors.New("user not present")
+	}
+	if ad.flags&authFlagAT != 0 {
+		rest := data[37:]
+		if len(rest) < 18 {
+			return nil, errBadPasskey
+		}
+		idlen := int(binary.BigEndian.Uint16(rest[16:]))
+		rest = rest[18:]
+		if len(rest) < idlen {
+			return nil, errBadPasskey
+		}
+		ad.credid = rest[:idlen]
+		rest = rest[idlen:]
+		_, after, err := cbormap(rest)
+		if err != nil {
+			return nil, err
+		}
+		ad.pubkey = rest[:len(rest)-len(after)]
+	}
+	return ad, nil
+}
+
+func getpasskeys(userid UserID) []*Passkey {
+	rows, err := stmtGetPasskeys.Query(userid)
+	if err != nil {
+		elog.Printf("error querying passkeys: %s", err)
+		return nil
+	}
+	defer rows.Close()
+	var keys []*Passkey
+	for rows.Next() {
+		pk, err := scanpasskey(rows)
+		if err != nil {
+			elog.Printf("error scanning passkey: %s", err)
+			continue
+		}
+		keys = append(keys, pk)
+	}
+	return keys
+}
+
+func scanpasskey(row RowLike) (*Passkey, error) {
+	pk := new(Passkey)
+	var pubkey, created, lastused string
+	err := row.Scan(&pk.ID, &pk.UserID, &pk.CredID, &pubkey, &pk.SignCount, &pk.Name, &created, &lastused)
+	if err != nil {
+		return nil, err
+	}
+	pk.PubKey, _ = base64.StdEncoding.DecodeString(pubkey)
+	pk.Created, _ = time.Parse(dbtimeformat, created)
+	pk.LastUsed, _ = time.Parse(dbtimeformat, lastused)
+	return pk, nil
+}
+
+type pkassertion struct {
+	ID                string `json:"id"`
+	ClientDataJSON    string `json:"clientDataJSON"`
+	AuthenticatorData string `json:"authenticatorData"`
+	Signature         string `json:"signature"`
+}
+
+// check a login assertion, for a known user or for anyone if userid is 0.
+// bound is the challenge this browser was given.
+func checkpasskey(userid UserID, assertion string, needuv bool, bound string) (UserID, error) {
+	var pa pkassertion
+	err := json.Unmarshal([]byte(assertion), &pa)
+	if err != nil {
+		return 0, err
+	}
+	cdata, err1 := b64url.DecodeString(pa.ClientDataJSON)
+	adata, err2 := b64url.DecodeString(pa.AuthenticatorData)
+	sig, err3 := b64url.DecodeString(pa.Signature)
+	if err1 != nil || err2 != nil || err3 != nil {
+		return 0, errBadPasskey
+	}
+	chuser, challenge, err := checkclientdata(cdata, "webauthn.get")
+	if err != nil {
+		return 0, err
+	}
+	if bound == "" || subtle.ConstantTimeCompare([]byte(challenge), []byte(bound)) != 1 {
+		return 0, errors.New("challenge from another browser")
+	}
+	pk, err := scanpasskey(stmtFindPasskey.QueryRow(pa.ID))
+	if err != nil {
+		return 0, err
+	}
+	if (userid != 0 && pk.UserID != userid) || (chuser != 0 && chuser != pk.UserID) {
+		return 0, errors.New("passkey for someone else")
+	}
+	ad, err := verifyassertion(pk, cdata, adata, sig, needuv)
+	if err != nil {
+		return 0, err
+	}
+	now := time.Now().UTC().Format(dbtimeformat)
+	_, err = stmtUsedPasskey.Exec(ad.signcount, now, pk.ID)
+	if err != nil {
+		elog.Printf("error updating passkey: %s", err)
+	}
+	return pk.UserID, nil
+}
+
+func verifyassertion(pk *Passkey, cdata, adata, sig []byte, needuv bool) (*authData, error) {
+	ad, err := parseauthdata(adata)
+	if err != nil {
+		return nil, err
+	}
+	if needuv && ad.flags&authFlagUV == 0 {
+		return nil, errors.New("user not verified")
+	}
+	sum := sha256.Sum256(cdata)
+	signed := append(append([]byte{}, adata...), sum[:]...)
+	err = coseverify(pk.PubKey, signed, sig)
+	if err != nil {
+		return nil, err
+	}
+	if (ad.signcount != 0 || pk.SignCount != 0) && ad.signcount <= pk.SignCount {
+		return nil, errors.New("passkey counter went backwards")
+	}
+	return ad, nil
+}
+
+func hashrecoverycode(code string) string {
+	code = strings.ToLower(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+	hasher := sha512.New512_256()
+	hasher.Write([]byte(code))
+	return fmt.Sprintf("%x", hasher.Sum(nil))
+}
+
+func saveoptions(user *WhatAbout, options UserOptions) error {
+	j, err := encodeJson(options)
+	if err == nil {
+		db := opendatabase()
+		_, err = db.Exec("update users set options = ? where username = ?", j, user.Name)
+	}
+	somenamedusers.Clear(user.Name)
+	somenumberedusers.Clear(user.ID)
+	return err
+}
+
+// returns the codes to show once, only hashes get saved
+func makerecoverycodes(userid UserID) ([]string, error) {
+	db := opendatabase()
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	_, err = tx.Stmt(stmtDeleteRecoveryCodes).Exec(userid)
+	if err != nil {
+		return nil, err
+	}
+	var codes []string
+	for i := 0; i < 10; i++ {
+		var buf [5]byte
+		rand.Read(buf[:])
+		code := fmt.Sprintf("%x", buf[:])
+		code = code[:5] + "-" + code[5:]
+		_, err = tx.Stmt(stmtSaveRecoveryCode).Exec(userid, hashrecoverycode(code))
+		if err != nil {
+			return nil, err
+		}
+		codes = append(codes, code)
+	}
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+func countrecoverycodes(userid UserID) int {
+	var n int
+	row := stmtCountRecoveryCodes.QueryRow(userid)
+	if row.Scan(&n) != nil {
+		return 0
+	}
+	return n
+}
+
+func checkpassword(user *WhatAbout, password string) bool {
+	var passhash []byte
+	db := opendatabase()
+	row := db.QueryRow("select hash from users where userid = ?", user.ID)
+	if row.Scan(&passhash) != nil {
+		return false
+	}
+	return bcrypt.CompareHashAndPassword(passhash, []byte(password)) == nil
+}
+
+// login checks the second factor even when the password is wrong,
+// so check it again here before spending a code.
+// only one delete can find the row, so a code can't be spent twice.
+func userecoverycode(user *WhatAbout, code string, password string) bool {
+	if len(code) < 10 {
+		return false
+	}
+	if !checkpassword(user, password) {
+		return false
+	}
+	res, err := stmtUseRecoveryCode.Exec(user.ID, hashrecoverycode(code))
+	if err != nil {
+		elog.Printf("error using recovery code: %s", err)
+		return false
+	}
+	if n, _ := res.RowsAffected(); n != 1 {
+		return false
+	}
+	ilog.Printf("recovery code used for %s", user.Name)
+	return true
+}
+
+func passkeychallenge(w http.ResponseWriter, r *http.Request) {
+	var userid UserID
+	var creds []junk.Junk
+	if name := r.FormValue("username"); name != "" {
+		user, err := getUserBio(name)
+		if err == nil {
+			userid = user.ID
+			for _, pk := range getpasskeys(user.ID) {
+				c := junk.New()
+				c["type"] = "public-key"
+				c["id"] = pk.CredID
+				creds = append(creds, c)
+			}
+		}
+	}
+	ch := newchallenge(userid)
+	http.SetCookie(w, &http.Cookie{
+		Name:     pkcookie,
+		Value:    ch,
+		MaxAge:   300,
+		Secure:   !develMode,
+		SameSite: http.SameSiteStrictMode,
+		HttpOnly: true,
+	})
+	j := junk.New()
+	j["challenge"] = ch
+	j["rpId"] = rpid()
+	j["allowCredentials"] = creds
+	j["userVerification"] = "preferred"
+	j["timeout"] = 120000
+	w.Header().Set("Content-Type", "application/json")
+	j.Write(w)
+}
+
+func passkeybegin(w http.ResponseWriter, r *http.Request) {
+	u := login.GetUserInfo(r)
+	userid := UserID(u.UserID)
+	j := junk.New()
+	j["challenge"] = newchallenge(userid)
+	rp := junk.New()
+	rp["id"] = rpid()
+	rp["name"] = serverName
+	j["rp"] = rp
+	user := junk.New()
+	user["id"] = b64url.EncodeToString([]byte(strconv.FormatInt(int64(userid), 10)))
+	user["name"] = u.Username
+	user["displayName"] = u.Username
+	j["user"] = user
+	var params []junk.Junk
+	for _, alg := range []int{-7, -8, -257} {
+		p := junk.New()
+		p["type"] = "public-key"
+		p["alg"] = alg
+		params = append(params, p)
+	}
+	j["pubKeyCredParams"] = params
+	var exclude []junk.Junk
+	for _, pk := range getpasskeys(userid) {
+		c := junk.New()
+		c["type"] = "public-key"
+		c["id"] = pk.CredID
+		exclude = append(exclude, c)
+	}
+	j["excludeCredentials"] = exclude
+	sel := junk.New()
+	sel["residentKey"] = "preferred"
+	sel["userVerification"] = "preferred"
+	j["authenticatorSelection"] = sel
+	j["attestation"] = "none"
+	j["timeout"] = 120000
+	w.Header().Set("Content-Type", "application/json")
+	j.Write(w)
+}
+
+func passkeyfinish(w http.ResponseWriter, r *http.Request) {
+	u := login.GetUserInfo(r)
+	user, _ := getUserBio(u.Username)
+	cdata, err1 := b64url.DecodeString(r.FormValue("clientDataJSON"))
+	attobj, err2 := b64url.DecodeString(r.FormValue("attestationObject"))
+	if err1 != nil || err2 != nil {
+		http.Error(w, "bad passkey", http.StatusBadRequest)
+		return
+	}
+	chuser, _, err := checkclientdata(cdata, "webauthn.create")
+	if err == nil && chuser != user.ID {
+		err = errors.New("challenge for someone else")
+	}
+	var ad *authData
+	if err == nil {
+		var att map[interface{}]interface{}
+		att, _, err = cbormap(attobj)
+		if err == nil {
+			authbytes, _ := att["authData"].([]byte)
+			ad, err = parseauthdata(authbytes)
+		}
+	}
+	if err == nil && (ad.credid == nil || ad.pubkey == nil) {
+		err = errBadPasskey
+	}
+	if err == nil {
+		// make sure we can use it before saving it
+		_, err = cosekey(ad.pubkey)
+	}
+	if err != nil {
+		ilog.Printf("passkey registration failed: %s", err)
+		http.Error(w, "bad passkey", http.StatusBadRequest)
+		return
+	}
+	name := strings.TrimSpace(r.FormValue("name"))
+	if name == "" {
+		name = "passkey"
+	}
+	now := time.Now().UTC().Format(dbtimeformat)
+	_, err = stmtSavePasskey.Exec(user.ID, b64url.EncodeToString(ad.credid),
+		base64.StdEncoding.EncodeToString(ad.pubkey), ad.signcount, name, now)
+	if err != nil {
+		elog.Printf("error saving passkey: %s", err)
+		http.Error(w, "error saving passkey", http.StatusInternalServerError)
+		return
+	}
+	ilog.Printf("new passkey %s for %s", name, user.Name)
+	j := junk.New()
+	if countrecoverycodes(user.ID) == 0 {
+		codes, err := makerecoverycodes(user.ID)
+		if err != nil {
+			elog.Printf("error saving recovery codes: %s", err)
+		} else {
+			j["recoverycodes"] = codes
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	j.Write(w)
+}
+
+func passkeydelete(w http.ResponseWriter, r *http.Request) {
+	u := login.GetUserInfo(r)
+	keyid, _ := strconv.ParseInt(r.FormValue("passkeyid"), 10, 0)
+	_, err := stmtDeletePasskey.Exec(keyid, u.UserID)
+	if err != nil {
+		elog.Printf("error deleting passkey: %s", err)
+	}
+	http.Redirect(w, r, "/account", http.StatusSeeOther)
+}
+
+func newrecoverycodes(w http.ResponseWriter, r *http.Request) {
+	u := login.GetUserInfo(r)
+	codes, err := makerecoverycodes(UserID(u.UserID))
+	if err != nil {
+		elog.Printf("error saving recovery codes: %s", err)
+		http.Error(w, "error saving codes", http.StatusInternalServerError)
+		return
+	}
+	showaccount(w, r, map[string]interface{}{"RecoveryCodes": codes})
+}
+
+// passwordless, the passkey has to verify the user itself
+func passkeylogin(w http.ResponseWriter, r *http.Request) {
+	var userid UserID
+	var err error
+	if origin := r.Header.Get("Origin"); origin != "" && !rporigin(origin) {
+		err = fmt.Errorf("wrong origin %s", origin)
+	} else {
+		userid, err = checkpasskey(0, r.FormValue("passkey"), true, pkcookiechallenge(r))
+	}
+	if err == nil {
+		if user, ok := somenumberedusers.Get(userid); !ok || suspended(user) {
+			err = errors.New("account suspended")
+		}
+	}
+	if err != nil {
+		ilog.Printf("passkey login failed: %s", err)
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+	var buf [32]byte
+	rand.Read(buf[:])
+	hasher := sha512.New512_256()
+	hasher.Write(buf[:])
+	auth := fmt.Sprintf("%x", hasher.Sum(nil))[0:authlen]
+	now := time.Now().UTC()
+	expiry := now.Add(7 * 24 * time.Hour).Format(dbtimeformat)
+	_, err = stmtSaveSession.Exec(userid, authhash(auth), expiry, now.Format(dbtimeformat))
+	if err != nil {
+		elog.Printf("error saving auth: %s", err)
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+	samesite := http.SameSiteStrictMode
+	if develMode || strings.Contains(r.UserAgent(), "iPhone") {
+		samesite = 0
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     "auth",
+		Value:    auth,
+		MaxAge:   3600 * 24 * 365,
+		Secure:   !develMode,
+		SameSite: samesite,
+		HttpOnly: true,
+	})
+	ilog.Printf("passkey login for %d", userid)
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+func passkeysecond(user *WhatAbout, r *http.Request) bool {
+	assertion := r.FormValue("passkey")
+	if assertion == "" {
+		return false
+	}
+	if origin := r.Header.Get("Origin"); origin != "" && !rporigin(origin) {
+		ilog.Printf("passkey check from wrong origin %s", origin)
+		return false
+	}
+	_, err := checkpasskey(user.ID, assertion, false, pkcookiechallenge(r))
+	if err != nil {
+		ilog.Printf("passkey check failed: %s", err)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"testing"
+)
+
+func testcosekey(pub *ecdsa.PublicKey) []byte {
+	var key []byte
+	key = append(key, 0xa5, 0x01, 0x02, 0x03, 0x26, 0x20, 0x01)
+	key = append(key, 0x21, 0x58, 0x20)
+	key = append(key, pub.X.FillBytes(make([]byte, 32))...)
+	key = append(key, 0x22, 0x58, 0x20)
+	key = append(key, pub.Y.FillBytes(make([]byte, 32))...)
+	return key
+}
+
+func testauthdata(rp string, flags byte, count uint32) []byte {
+	sum := sha256.Sum256([]byte(rp))
+	adata := append([]byte{}, sum[:]...)
+	adata = append(adata, flags)
+	return binary.BigEndian.AppendUint32(adata, count)
+}
+
+func testsign(t *testing.T, priv *ecdsa.PrivateKey, adata, cdata []byte) []byte {
+	sum := sha256.Sum256(cdata)
+	signed := append(append([]byte{}, adata...), sum[:]...)
+	digest := sha256.Sum256(signed)
+	sig, err := ecdsa.SignASN1(rand.Reader, priv, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sig
+}
+
+func TestVerifyAssertion(t *testing.T) {
+	serverName = "honk.example"
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cdata := []byte(`{"type":"webauthn.get"}`)
+	tests := []struct {
+		name    string
+		adata   []byte
+		stored  uint32
+		needuv  bool
+		mangle  bool
+		wantErr bool
+	}{
+		{"good", testauthdata("honk.example", authFlagUP, 7), 6, false, false, false},
+		{"no counters", testauthdata("honk.example", authFlagUP, 0), 0, false, false, false},
+		{"verified", testauthdata("honk.example", authFlagUP|authFlagUV, 1), 0, true, false, false},
+		{"bad signature", testauthdata("honk.example", authFlagUP, 7), 6, false, true, true},
+		{"wrong rp", testauthdata("evil.example", authFlagUP, 7), 6, false, false, true},
+		{"counter same", testauthdata("honk.example", authFlagUP, 6), 6, false, false, true},
+		{"counter backwards", testauthdata("honk.example", authFlagUP, 3), 6, false, false, true},
+		{"counter reset", testauthdata("honk.example", authFlagUP, 0), 6, false, false, true},
+		{"not present", testauthdata("honk.example", 0, 7), 6, false, false, true},
+		{"not verified", testauthdata("honk.example", authFlagUP, 7), 6, true, false, true},
+		{"truncated", testauthdata("honk.example", authFlagUP, 7)[:36], 6, false, false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pk := &Passkey{PubKey: testcosekey(&priv.PublicKey), SignCount: tt.stored}
+			sig := testsign(t, priv, tt.adata, cdata)
+			if tt.mangle {
+				sig[len(sig)-1] ^= 1
+			}
+			_, err := verifyassertion(pk, cdata, tt.adata, sig, tt.needuv)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("got error %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCborHostile(t *testing.T) {
+	priv, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	key := testcosekey(&priv.PublicKey)
+	if _, err := cosekey(key); err != nil {
+		t.Fatalf("good key: %s", err)
+	}
+	for i := 0; i < len(key); i++ {
+		if _, err := cosekey(key[:i]); err == nil {
+			t.Errorf("key truncated to %d accepted", i)
+		}
+	}
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"empty", nil},
+		{"huge map", []byte{0xbb, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}},
+		{"huge bytes", []byte{0xa1, 0x01, 0x5b, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}},
+		{"huge array", []byte{0xa1, 0x01, 0x9b, 0x7f, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}},
+		{"short length", []byte{0xa1, 0x01, 0x59, 0x01}},
+		{"indefinite", []byte{0xbf, 0x01, 0x02, 0xff}},
+		{"deep", append(bytes.Repeat([]byte{0xa1, 0x01}, 1000), 0x01)},
+		{"deep tags", append([]byte{0xa1, 0x01}, bytes.Repeat([]byte{0xc1}, 1000)...)},
+		{"not a map", []byte{0x83, 0x01, 0x02, 0x03}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, _, err := cbormap(tt.data); err == nil {
+				t.Errorf("accepted %x", tt.data)
+			}
+		})
+	}
+}
+
+func TestAuthDataAttested(t *testing.T) {
+	serverName = "honk.example"
+	priv, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	key := testcosekey(&priv.PublicKey)
+	adata := testauthdata("honk.example", authFlagUP|authFlagAT, 0)
+	adata = append(adata, make([]byte, 16)...)
+	adata = append(adata, 0x00, 0x04, 'c', 'r', 'e', 'd')
+	full := append(adata, key...)
+	ad, err := parseauthdata(full)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(ad.credid) != "cred" || !bytes.Equal(ad.pubkey, key) {
+		t.Errorf("wrong cred %q or key", ad.credid)
+	}
+	for i := 37; i < len(full); i++ {
+		if _, err := parseauthdata(full[:i]); err == nil {
+			t.Errorf("authdata truncated to %d accepted", i)
+		}
+	}
+	long := append(append([]byte{}, adata[:len(adata)-6]...), 0xff, 0xff, 'c')
+	if _, err := parseauthdata(long); err == nil {
+		t.Errorf("overlong cred id accepted")
+	}
+}
+
+func TestChallenges(t *testing.T) {
+	for i := 0; i < 3*maxpkchallenges; i++ {
+		newchallenge(0)
+	}
+	if len(pkchallenges) > maxpkchallenges {
+		t.Errorf("%d challenges kept", len(pkchallenges))
+	}
+	ch := newchallenge(5)
+	if userid, ok := takechallenge(ch); !ok || userid != 5 {
+		t.Errorf("fresh challenge refused")
+	}
+	if _, ok := takechallenge(ch); ok {
+		t.Errorf("challenge used twice")
+	}
+}
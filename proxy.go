@@ -0,0 +1,311 @@
+//
+// Copyright (c) 2024 Ted Unangst <tedu@tedunangst.com>
+//
+// Permission to use, copy, modify, and distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package main
+
+import (
+	"context"
+	"crypto/sha512"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"humungus.tedunangst.com/r/webs/gate"
+	"humungus.tedunangst.com/r/webs/image"
+)
+
+// lil is for avatars, big for attachments
+var proxySizes = map[string]image.Params{
+	"lil": {
+		LimitSize: 14200 * 4200,
+		MaxWidth:  256,
+		MaxHeight: 256,
+		MaxSize:   16 * 1024,
+	},
+	"big": {
+		LimitSize: 14200 * 4200,
+		MaxWidth:  2600,
+		MaxHeight: 2048,
+		MaxSize:   768 * 1024,
+	},
+}
+
+const proxyCacheDuration = 7 * 24 * time.Hour
+const proxyCacheLimit = 512 * 1024 * 1024
+
+var proxygate = gate.NewLimiter(8)
+
+// same rules as cards, but its own connections, since streams last a while
+var proxyTransport = http.Transport{
+	DialContext:         (&net.Dialer{Control: publicdial, Timeout: 10 * time.Second}).DialContext,
+	MaxIdleConns:        20,
+	IdleConnTimeout:     90 * time.Second,
+	TLSHandshakeTimeout: 10 * time.Second,
+}
+
+var proxyClient = http.Client{
+	Transport: &proxyTransport,
+}
+
+func proxydir() string {
+	return dataDir + "/proxycache"
+}
+
+func proxyURL(u string, size string) string {
+	return "/proxy?s=" + size + "&u=" + url.QueryEscape(u)
+}
+
+func proxykey(u string, size string) string {
+	hasher := sha512.New512_256()
+	hasher.Write([]byte(size))
+	hasher.Write([]byte{0})
+	hasher.Write([]byte(u))
+	return hex.EncodeToString(hasher.Sum(nil))
+}
+
+func proxyable(media string) bool {
+	return strings.HasPrefix(media, "image/") || streamable(media)
+}
+
+// too big to hold, so passed along as they come
+func streamable(media string) bool {
+	return strings.HasPrefix(media, "video/") || strings.HasPrefix(media, "audio/")
+}
+
+func proxyget(ctx context.Context, u string, rng string) (*http.Response, error) {
+	pu, err := url.Parse(u)
+	if err != nil {
+		return nil, err
+	}
+	err = publichost(ctx, pu.Hostname())
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", u, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", "honksnonk/5.0; "+serverName)
+	if rng != "" {
+		req.Header.Set("Range", rng)
+	}
+	return proxyClient.Do(req)
+}
+
+var errTooBig = errors.New("too big to proxy")
+
+// images only, resized and cached
+func proxyfetch(u string, size string) ([]byte, string, error) {
+	proxygate.StartKey(u)
+	defer proxygate.FinishKey(u)
+
+	fname := proxydir() + "/" + proxykey(u, size)
+	if s, err := os.Stat(fname); err == nil && time.Since(s.ModTime()) < proxyCacheDuration {
+		data, err := os.ReadFile(fname)
+		if err == nil {
+			// mtime doubles as last use for the sweeper
+			now := time.Now()
+			os.Chtimes(fname, now, now)
+			media := http.DetectContentType(data)
+			if isSVG(data) {
+				media = "image/svg+xml"
+			}
+			return data, media, nil
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Minute)
+	defer cancel()
+	resp, err := proxyget(ctx, u, "")
+	if err != nil {
+		ilog.Printf("error proxying %s: %s", u, err)
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return nil, "", fmt.Errorf("http get not 200: %d %s", resp.StatusCode, u)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxFetchSize+1))
+	if err != nil {
+		return nil, "", err
+	}
+	if len(data) > maxFetchSize {
+		return nil, "", errTooBig
+	}
+	media := http.DetectContentType(data)
+	if !strings.HasPrefix(media, "image/") && !isSVG(data) {
+		return nil, media, nil
+	}
+	img, err := callshrink(data, proxySizes[size])
+	if err != nil {
+		ilog.Printf("unable to shrink proxied image %s: %s", u, err)
+		return nil, "", err
+	}
+	data = img.Data
+	media = "image/" + img.Format
+	os.MkdirAll(proxydir(), 0777)
+	err = os.WriteFile(fname, data, 0644)
+	if err != nil {
+		elog.Printf("error saving proxy cache: %s", err)
+	}
+	return data, media, nil
+}
+
+// no cache, but ranges go through so players can seek
+func proxystream(w http.ResponseWriter, r *http.Request, u string) {
+	resp, err := proxyget(r.Context(), u, r.Header.Get("Range"))
+	if err != nil {
+		ilog.Printf("error proxying %s: %s", u, err)
+		http.Error(w, "unable to fetch", http.StatusBadGateway)
+		return
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusPartialContent:
+	case http.StatusRequestedRangeNotSatisfiable:
+		w.WriteHeader(resp.StatusCode)
+		return
+	default:
+		http.Error(w, "unable to fetch", http.StatusBadGateway)
+		return
+	}
+	media, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if !streamable(media) {
+		http.Error(w, "unsupported media", http.StatusUnsupportedMediaType)
+		return
+	}
+	hdr := w.Header()
+	hdr.Set("Content-Type", media)
+	for _, k := range []string{"Content-Length", "Content-Range", "Accept-Ranges", "Last-Modified", "ETag"} {
+		if v := resp.Header.Get(k); v != "" {
+			hdr.Set(k, v)
+		}
+	}
+	hdr.Set("X-Content-Type-Options", "nosniff")
+	hdr.Set("Cache-Control", "max-age=432000")
+	w.WriteHeader(resp.StatusCode)
+	io.Copy(w, resp.Body)
+}
+
+func proxyhandler(w http.ResponseWriter, r *http.Request) {
+	u := r.FormValue("u")
+	size := r.FormValue("s")
+	if _, ok := proxySizes[size]; !ok {
+		size = "big"
+	}
+	if !strings.HasPrefix(u, "https://") && !strings.HasPrefix(u, "http://") {
+		http.NotFound(w, r)
+		return
+	}
+	// only things we've seen as attachments, not an open relay
+	media, ok := knownremotedonk(u)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	if streamable(media) {
+		proxystream(w, r, u)
+		return
+	}
+	data, media, err := proxyfetch(u, size)
+	if err != nil {
+		http.Error(w, "unable to fetch", http.StatusBadGateway)
+		return
+	}
+	if !strings.HasPrefix(media, "image/") {
+		http.Error(w, "unsupported media", http.StatusUnsupportedMediaType)
+		return
+	}
+	if media == "image/svg+xml" {
+		w.Header().Set("Content-Security-Policy", "sandbox")
+	}
+	w.Header().Set("Content-Type", media)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Cache-Control", "max-age=432000")
+	w.Write(data)
+}
+
+func knownremotedonk(u string) (string, bool) {
+	row := stmtFindRemoteFile.QueryRow(u)
+	var media string
+	err := row.Scan(&media)
+	return media, err == nil
+}
+
+// expire unused entries, then trim least recently used until under the limit
+func sweepproxycache() {
+	type cached struct {
+		name string
+		size int64
+		used time.Time
+	}
+	var files []cached
+	var total int64
+	walker := func(pathname string, ent fs.DirEntry, err error) error {
+		if err != nil || ent.IsDir() {
+			return nil
+		}
+		info, err := ent.Info()
+		if err != nil {
+			return nil
+		}
+		fname := proxydir() + "/" + ent.Name()
+		if time.Since(info.ModTime()) > proxyCacheDuration {
+			os.Remove(fname)
+			return nil
+		}
+		files = append(files, cached{fname, info.Size(), info.ModTime()})
+		total += info.Size()
+		return nil
+	}
+	fs.WalkDir(os.DirFS(proxydir()), ".", walker)
+	if total <= proxyCacheLimit {
+		return
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].used.Before(files[j].used)
+	})
+	for _, f := range files {
+		if total <= proxyCacheLimit {
+			break
+		}
+		os.Remove(f.name)
+		total -= f.size
+	}
+}
+
+func proxysweeper() {
+	workinprogress++
+	sleeper := time.NewTimer(5 * time.Minute)
+	for {
+		select {
+		case <-sleeper.C:
+		case <-endoftheworld:
+			readyalready <- true
+			return
+		}
+		sweepproxycache()
+		sleeper.Reset(1 * time.Hour)
+	}
+}
@@ -0,0 +1,166 @@
+//
+// Copyright (c) 2024 Ted Unangst <tedu@tedunangst.com>
+//
+// Permission to use, copy, modify, and distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package main
+
+import (
+	"errors"
+	notrand "math/rand"
+	"sync"
+	"time"
+)
+
+var errQuota = errors.New("over quota")
+
+type Quota struct {
+	Megabytes      int64
+	Honks          int64
+	Deliveries     int64
+	UsedBytes      int64
+	UsedHonks      int64
+	UsedDeliveries int64
+}
+
+func (q Quota) UsedMegabytes() float64 {
+	return float64(q.UsedBytes) / 1000000
+}
+
+// user options override the defaults, negative means no limit
+func quotalimit(mine, def int64) int64 {
+	if mine < 0 {
+		return 0
+	}
+	if mine > 0 {
+		return mine
+	}
+	return def
+}
+
+func getquota(user *WhatAbout) Quota {
+	var q Quota
+	q.Megabytes = quotalimit(user.Options.QuotaMegabytes, live().QuotaMegabytes)
+	q.Honks = quotalimit(user.Options.QuotaHonks, live().QuotaHonks)
+	q.Deliveries = quotalimit(user.Options.QuotaDeliveries, live().QuotaDeliveries)
+	q.UsedBytes = attachmentbytes(user)
+	q.UsedHonks = recenthonks(user)
+	q.UsedDeliveries = deliveryslots.used(user.ID)
+	return q
+}
+
+// attachments on our own honks and chonks
+func attachmentbytes(user *WhatAbout) int64 {
+	rows, err := stmtUserFileMeta.Query(user.ID, user.ID, user.URL)
+	if err != nil {
+		elog.Printf("error querying file usage: %s", err)
+		return 0
+	}
+	defer rows.Close()
+	var total int64
+	for rows.Next() {
+		var j string
+		err = rows.Scan(&j)
+		if err != nil {
+			elog.Printf("error scanning file usage: %s", err)
+			continue
+		}
+		var meta DonkMeta
+		decodeJson(j, &meta)
+		total += int64(meta.Length)
+	}
+	return total
+}
+
+func recenthonks(user *WhatAbout) int64 {
+	dt := time.Now().Add(-time.Hour).UTC().Format(dbtimeformat)
+	var count int64
+	err := stmtRecentHonks.QueryRow(user.ID, dt).Scan(&count)
+	if err != nil {
+		elog.Printf("error counting honks: %s", err)
+	}
+	return count
+}
+
+func roomforbytes(user *WhatAbout, size int) bool {
+	limit := quotalimit(user.Options.QuotaMegabytes, live().QuotaMegabytes)
+	if limit == 0 {
+		return true
+	}
+	return attachmentbytes(user)+int64(size) <= limit*1000000
+}
+
+func roomforhonk(user *WhatAbout) bool {
+	limit := quotalimit(user.Options.QuotaHonks, live().QuotaHonks)
+	if limit == 0 {
+		return true
+	}
+	return recenthonks(user) < limit
+}
+
+// hourly delivery budget per user.
+// anything over budget is charged to the next hour with room.
+type deliveryledger struct {
+	sync.Mutex
+	hours map[UserID]map[int64]int64
+}
+
+var deliveryslots = deliveryledger{hours: make(map[UserID]map[int64]int64)}
+
+func (dl *deliveryledger) used(userid UserID) int64 {
+	dl.Lock()
+	defer dl.Unlock()
+	now := time.Now().Unix() / 3600
+	return dl.hours[userid][now]
+}
+
+// returns when each delivery may go out, zero time for now
+func (dl *deliveryledger) schedule(userid UserID, limit int64, count int) []time.Time {
+	times := make([]time.Time, count)
+	dl.Lock()
+	defer dl.Unlock()
+	now := time.Now().Unix() / 3600
+	slots := dl.hours[userid]
+	if slots == nil {
+		slots = make(map[int64]int64)
+		dl.hours[userid] = slots
+	}
+	for hour := range slots {
+		if hour < now {
+			delete(slots, hour)
+		}
+	}
+	hour := now
+	for i := range times {
+		for limit > 0 && slots[hour] >= limit {
+			hour++
+		}
+		slots[hour]++
+		if hour != now {
+			// spread them out over the hour
+			times[i] = time.Unix(hour*3600+notrand.Int63n(3600), 0)
+		}
+	}
+	return times
+}
+
+func postpone(userid UserID, rcpt string, msg []byte, when time.Time) {
+	_, err := stmtAddDoover.Exec(when.UTC().Format(dbtimeformat), 0, userid, rcpt, msg)
+	if err != nil {
+		elog.Printf("error saving doover: %s", err)
+	}
+	select {
+	case pokechan <- 0:
+	default:
+	}
+}
@@ -0,0 +1,163 @@
+//
+// Copyright (c) 2024 Ted Unangst <tedu@tedunangst.com>
+//
+// Permission to use, copy, modify, and distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package main
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"humungus.tedunangst.com/r/webs/htfilter"
+	"humungus.tedunangst.com/r/webs/login"
+)
+
+type DiffWord struct {
+	Text string
+	Op   string // "", "add", or "del"
+}
+
+// one step in the history, compared to the one before
+type Revision struct {
+	Date  time.Time
+	Words []DiffWord
+	Alts  []DiffWord
+}
+
+func (rev *OldRevision) words() []string {
+	var filt htfilter.Filter
+	var text []string
+	if rev.Precis != "" {
+		t, _ := filt.TextOnly(rev.Precis)
+		text = append(text, t)
+	}
+	if rev.Format == "markdown" {
+		text = append(text, rev.Noise)
+	} else {
+		t, _ := filt.TextOnly(rev.Noise)
+		text = append(text, t)
+	}
+	return strings.Fields(strings.Join(text, " "))
+}
+
+func (rev *OldRevision) altwords() []string {
+	return strings.Fields(strings.Join(rev.Alts, " "))
+}
+
+// longest common subsequence, a word at a time.
+// very long honks just get replaced wholesale.
+func worddiff(a, b []string) []DiffWord {
+	var diff []DiffWord
+	if len(a)*len(b) > 1000000 {
+		for _, w := range a {
+			diff = append(diff, DiffWord{Text: w, Op: "del"})
+		}
+		for _, w := range b {
+			diff = append(diff, DiffWord{Text: w, Op: "add"})
+		}
+		return diff
+	}
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		if a[i] == b[j] {
+			diff = append(diff, DiffWord{Text: a[i]})
+			i++
+			j++
+		} else if lcs[i+1][j] >= lcs[i][j+1] {
+			diff = append(diff, DiffWord{Text: a[i], Op: "del"})
+			i++
+		} else {
+			diff = append(diff, DiffWord{Text: b[j], Op: "add"})
+			j++
+		}
+	}
+	for ; i < len(a); i++ {
+		diff = append(diff, DiffWord{Text: a[i], Op: "del"})
+	}
+	for ; j < len(b); j++ {
+		diff = append(diff, DiffWord{Text: b[j], Op: "add"})
+	}
+	return diff
+}
+
+// newest first, the original last with nothing to compare against
+func revisionhistory(honk *ActivityPubActivity) []Revision {
+	versions := append([]OldRevision{}, honk.Revisions...)
+	versions = append(versions, OldRevision{Precis: honk.Precis, Noise: honk.Noise,
+		Format: honk.Format, Date: honk.Date, Alts: donkalts(honk.Donks)})
+	var history []Revision
+	var prev, prevalts []string
+	for i, v := range versions {
+		words := v.words()
+		alts := v.altwords()
+		rev := Revision{Date: v.Date}
+		if i == 0 {
+			for _, w := range words {
+				rev.Words = append(rev.Words, DiffWord{Text: w})
+			}
+		} else {
+			rev.Words = worddiff(prev, words)
+		}
+		// unknown before, so nothing to compare
+		if i == 0 || versions[i-1].Alts == nil {
+			for _, w := range alts {
+				rev.Alts = append(rev.Alts, DiffWord{Text: w})
+			}
+		} else {
+			rev.Alts = worddiff(prevalts, alts)
+		}
+		history = append(history, rev)
+		prev = words
+		prevalts = alts
+	}
+	for i, j := 0, len(history)-1; i < j; i, j = i+1, j-1 {
+		history[i], history[j] = history[j], history[i]
+	}
+	return history
+}
+
+// only our own view, the history of a honk isn't for visitors
+func showrevisions(w http.ResponseWriter, r *http.Request) {
+	u := login.GetUserInfo(r)
+	honk := getActivityPubActivity(UserID(u.UserID), r.FormValue("xid"))
+	if honk == nil {
+		http.NotFound(w, r)
+		return
+	}
+	// revisions are kept with the donks
+	donksforhonks([]*ActivityPubActivity{honk})
+	templinfo := getInfo(r)
+	templinfo["Honk"] = honk
+	templinfo["Revisions"] = revisionhistory(honk)
+	err := readviews.Execute(w, "revisions.html", templinfo)
+	if err != nil {
+		elog.Print(err)
+	}
+}
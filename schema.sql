@@ -1,5 +1,5 @@
 create table honks (honkid integer primary key, userid integer, what text, honker text, xid text, rid text, dt text, url text, audience text, noise text, convoy text, whofore integer, format text, precis text, oonker text, flags integer, plain text);
-create table chonks (chonkid integer primary key, userid integer, xid text, who txt, target text, dt text, noise text, format text);
+create table chonks (chonkid integer primary key, userid integer, xid text, who txt, target text, dt text, noise text, format text, newkey text);
 create table donks (honkid integer, chonkid integer, fileid integer);
 create table filemeta (fileid integer primary key, xid text, name text, description text, url text, media text, local integer, meta text);
 create table filehashes (xid text, hash text, media text);
@@ -9,7 +9,7 @@ create table zonkers (zonkerid integer primary key, userid integer, name text, w
 create table doovers(dooverid integer primary key, dt text, tries integer, userid integer, rcpt text, msg blob);
 create table onts (ontology text, honkid integer);
 create table honkmeta (honkid integer, genus text, json text);
-create table hfcs (hfcsid integer primary key, userid integer, json text);
+create table hfcs (hfcsid integer primary key, userid integer, json text, hits integer, lasthit text);
 create table tracks (xid text, fetches text);
 
 create index idx_honksxid on honks(xid);
@@ -22,6 +22,7 @@ create index idx_honksforme on honks(whofore) where whofore = 1;
 create index idx_honkswhotwo on honks(whofore) where whofore = 2;
 create index idx_donkshonk on donks(honkid);
 create index idx_donkschonk on donks(chonkid);
+create index idx_chonksuser on chonks(userid, target);
 create index idx_honkerxid on honkers(xid);
 create index idx_xonkername on xonkers(name);
 create index idx_zonkersname on zonkers(name);
@@ -37,9 +38,27 @@ create index idx_trackhonkid on tracks(xid);
 
 create table config (key text, value text);
 
-create table users (userid integer primary key, username text, hash text, displayname text, about text, pubkey text, seckey text, options text);
-create table auth (authid integer primary key, userid integer, hash text, expiry text);
-CREATE index idxusers_username on users(username);
+create table users (userid integer primary key, username text, hash text, displayname text, about text, pubkey text, seckey text, options text, userflags integer);
+create table auth (authid integer primary key, userid integer, hash text, expiry text, name text, scopes text, created text, lastused text, deadline text, ip text, agent text);
+CREATE unique index idxusers_username on users(username);
 CREATE index idxauth_userid on auth(userid);
 CREATE index idxauth_hash on auth(hash);
-
+create table passkeys (passkeyid integer primary key, userid integer, credid text, pubkey text, signcount integer, name text, created text, lastused text);
+create index idxpasskeys_userid on passkeys(userid);
+create index idxpasskeys_credid on passkeys(credid);
+create table recoverycodes (recoveryid integer primary key, userid integer, hash text);
+create index idxrecoverycodes_userid on recoverycodes(userid);
+create table invites (inviteid integer primary key, userid integer, code text, created text, expiry text, maxuses integer, uses integer, approval integer);
+create index idxinvites_code on invites(code);
+create table drafts (draftid integer primary key, userid integer, dt text, publish text, form text);
+create index idxdrafts_userid on drafts(userid);
+create table chatgroups (chatgroupid integer primary key, userid integer, target text, name text);
+create index idx_chatgroupsuser on chatgroups(userid);
+create table chatpins (chatpinid integer primary key, userid integer, xid text, pubkey text, dt text);
+create index idx_chatpinsuser on chatpins(userid, xid);
+create table spamsamples (sampleid integer primary key, userid integer, xid text, spam integer, words text, dt text);
+create index idx_spamsamplesuser on spamsamples(userid, xid);
+create table notices (noticeid integer primary key, userid integer, what text, who text, xid text, content text, dt text, seen integer);
+create index idx_noticesuser on notices(userid, noticeid);
+create table circles (circleid integer primary key, userid integer, name text, combos text, members text);
+create index idx_circlesuser on circles(userid);
@@ -0,0 +1,203 @@
+//
+// Copyright (c) 2024 Ted Unangst <tedu@tedunangst.com>
+//
+// Permission to use, copy, modify, and distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package main
+
+import (
+	"math"
+	"strings"
+	"time"
+	"unicode"
+
+	"humungus.tedunangst.com/r/webs/gencache"
+)
+
+// kept per user and per verdict, the oldest go first
+const maxSpamSamples = 2000
+
+// not enough of either and every guess is noise
+const minSpamSamples = 20
+
+const spamThreshold = 0.95
+
+// how many samples of each kind had each word, spam first
+type SpamModel struct {
+	Spams int
+	Hams  int
+	Words map[string][2]int
+}
+
+var spamInvalidator gencache.Invalidator[UserID]
+var spammodels = gencache.New(gencache.Options[UserID, *SpamModel]{Fill: func(userid UserID) (*SpamModel, bool) {
+	rows, err := stmtGetSpamSamples.Query(userid)
+	if err != nil {
+		elog.Printf("error querying spam samples: %s", err)
+		return nil, false
+	}
+	defer rows.Close()
+	model := &SpamModel{Words: make(map[string][2]int)}
+	for rows.Next() {
+		var spam int
+		var words string
+		err = rows.Scan(&spam, &words)
+		if err != nil {
+			elog.Printf("error scanning spam sample: %s", err)
+			continue
+		}
+		if spam != 0 {
+			model.Spams++
+		} else {
+			model.Hams++
+		}
+		for _, w := range strings.Fields(words) {
+			c := model.Words[w]
+			c[1-spam]++
+			model.Words[w] = c
+		}
+	}
+	return model, true
+}, Invalidator: &spamInvalidator})
+
+func spamminded(user *WhatAbout) bool {
+	return user.Options.SpamFilter != ""
+}
+
+// each word once, and a few things that aren't words
+func spamwords(h *ActivityPubActivity) []string {
+	seen := make(map[string]bool)
+	var words []string
+	add := func(w string) {
+		if !seen[w] && len(words) < 300 {
+			seen[w] = true
+			words = append(words, w)
+		}
+	}
+	if origin := originate(honkauthor(h)); origin != "" {
+		add("from:" + origin)
+	}
+	if len(h.Donks) > 0 {
+		add("has:media")
+	}
+	if len(h.Mentions) > 2 {
+		add("has:mentions")
+	}
+	plain := strings.ToLower(h.Plain())
+	for _, w := range strings.FieldsFunc(plain, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	}) {
+		if len(w) < 2 || len(w) > 30 {
+			continue
+		}
+		add(w)
+	}
+	return words
+}
+
+// remember what the user thought of this one
+func spamsample(user *WhatAbout, h *ActivityPubActivity, spam bool) {
+	if !spamminded(user) || h.Honker == user.URL {
+		return
+	}
+	verdict := 0
+	if spam {
+		verdict = 1
+	}
+	dt := time.Now().UTC().Format(dbtimeformat)
+	_, err := stmtDeleteSpamSample.Exec(user.ID, h.XID)
+	if err == nil {
+		_, err = stmtSaveSpamSample.Exec(user.ID, h.XID, verdict, strings.Join(spamwords(h), " "), dt)
+	}
+	if err != nil {
+		elog.Printf("error saving spam sample: %s", err)
+	}
+}
+
+// naive bayes, with every word counted as if it had nothing to do with the others
+func spamscore(userid UserID, h *ActivityPubActivity) (float64, bool) {
+	model, ok := spammodels.Get(userid)
+	if !ok || model.Spams < minSpamSamples || model.Hams < minSpamSamples {
+		return 0, false
+	}
+	spams := float64(model.Spams)
+	hams := float64(model.Hams)
+	odds := math.Log(spams / hams)
+	for _, w := range spamwords(h) {
+		c, ok := model.Words[w]
+		if !ok {
+			continue
+		}
+		odds += math.Log((float64(c[0])+1)/(spams+2)) - math.Log((float64(c[1])+1)/(hams+2))
+	}
+	return 1 / (1 + math.Exp(-odds)), true
+}
+
+// only strangers get judged
+func spamcheck(user *WhatAbout, xonk *ActivityPubActivity) {
+	if !spamminded(user) || !unknownActor(user.ID, xonk.Honker) {
+		return
+	}
+	score, ok := spamscore(user.ID, xonk)
+	if ok && score >= spamThreshold {
+		ilog.Printf("possible spam for %s: %s %.3f", user.Name, xonk.XID, score)
+		xonk.Flags |= flagIsSpammy
+	}
+}
+
+func quarantined(userid UserID) bool {
+	user, ok := somenumberedusers.Get(userid)
+	return ok && user.Options.SpamFilter == "quarantine"
+}
+
+func notspam(user *WhatAbout, xonk *ActivityPubActivity) {
+	_, err := stmtClearFlags.Exec(flagIsSpammy, xonk.ID)
+	if err != nil {
+		elog.Printf("error clearing spam: %s", err)
+	}
+	spamsample(user, xonk, false)
+}
+
+func getspamhonks(userid UserID) []*ActivityPubActivity {
+	return getsomehonks(stmtSpamHonks.Query(userid, flagIsSpammy))
+}
+
+// old samples make way for new ones, then the model starts over
+func retrainspam(user *WhatAbout) {
+	for _, verdict := range []int{0, 1} {
+		_, err := stmtPruneSpamSamples.Exec(user.ID, verdict, user.ID, verdict, maxSpamSamples)
+		if err != nil {
+			elog.Printf("error pruning spam samples: %s", err)
+		}
+	}
+	spamInvalidator.Clear(user.ID)
+}
+
+func spamtrainer() {
+	workinprogress++
+	sleeper := time.NewTimer(5 * time.Minute)
+	for {
+		select {
+		case <-sleeper.C:
+		case <-endoftheworld:
+			readyalready <- true
+			return
+		}
+		for _, user := range getallusers() {
+			if spamminded(user) && !suspended(user) {
+				retrainspam(user)
+			}
+		}
+		sleeper.Reset(1 * time.Hour)
+	}
+}
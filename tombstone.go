@@ -0,0 +1,187 @@
+//
+// Copyright (c) 2024 Ted Unangst <tedu@tedunangst.com>
+//
+// Permission to use, copy, modify, and distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"humungus.tedunangst.com/r/webs/junk"
+	"humungus.tedunangst.com/r/webs/login"
+)
+
+// Deleted accounts keep their user row, and the keys in it,
+// so the deletes can still be signed and the name isn't reused.
+
+func marktombstone(user *WhatAbout) error {
+	var options UserOptions
+	options.Deleted = true
+	j, err := encodeJson(options)
+	if err != nil {
+		return err
+	}
+	db := opendatabase()
+	_, err = db.Exec("update users set hash = '*', displayname = username, about = '', options = ? where userid = ?", j, user.ID)
+	somenamedusers.Clear(user.Name)
+	somenumberedusers.Clear(user.ID)
+	oldfingers.Flush()
+	authcache.Flush()
+	return err
+}
+
+// every inbox we've ever heard of
+func knowninboxes() []string {
+	rows, err := opendatabase().Query("select info from xonkers where flavor = 'boxes'")
+	if err != nil {
+		elog.Printf("error querying boxes: %s", err)
+		return nil
+	}
+	defer rows.Close()
+	var boxes []string
+	for rows.Next() {
+		var info string
+		err = rows.Scan(&info)
+		if err != nil {
+			elog.Printf("error scanning boxes: %s", err)
+			continue
+		}
+		m := strings.Split(info, " ")
+		if len(m) != 3 {
+			continue
+		}
+		if m[2] != "" {
+			boxes = append(boxes, "%"+m[2])
+		} else {
+			boxes = append(boxes, "%"+m[0])
+		}
+	}
+	return boxes
+}
+
+// one actor delete to every inbox we know takes the honks with it.
+// from the command line, the deletes are queued for the server to send.
+func closeaccount(user *WhatAbout, later bool) {
+	ilog.Printf("closing account %s", user.Name)
+	err := marktombstone(user)
+	if err != nil {
+		elog.Printf("error marking tombstone: %s", err)
+	}
+	now := time.Now().UTC()
+
+	var aud []string
+	for _, h := range getdubs(user.ID) {
+		aud = append(aud, h.XID)
+	}
+	rcpts := boxuprcpts(user, aud, true)
+	for _, rcpt := range knowninboxes() {
+		rcpts[rcpt] = true
+	}
+
+	j := junk.New()
+	j["@context"] = itiswhatitis
+	j["id"] = user.URL + "#delete"
+	j["type"] = "Delete"
+	j["actor"] = user.URL
+	j["object"] = user.URL
+	j["to"] = []string{atContextString}
+	j["published"] = now.Format(time.RFC3339)
+	gone := j.ToBytes()
+
+	purgeuserdata(opendatabase(), user.ID)
+
+	for rcpt := range rcpts {
+		if later {
+			postpone(user.ID, rcpt, gone, now)
+		} else {
+			go deliverate(user.ID, rcpt, gone)
+		}
+	}
+	ilog.Printf("sending delete for %s to %d inboxes", user.Name, len(rcpts))
+}
+
+func clideluser(args []string) {
+	if len(args) < 2 {
+		errx("usage: honk deluser username [zipname]")
+	}
+	user, err := getUserBio(args[1])
+	if err != nil {
+		errx("user %s not found", args[1])
+	}
+	if user.Options.Deleted {
+		errx("user %s is already deleted", user.Name)
+	}
+	if len(args) > 2 {
+		fd, err := os.OpenFile(args[2], os.O_RDWR|os.O_CREATE|os.O_EXCL, 0666)
+		if err != nil {
+			errx("can't create archive: %s", err)
+		}
+		err = exportarchive(user, fd)
+		if err == nil {
+			err = fd.Close()
+		}
+		if err != nil {
+			errx("error exporting: %s", err)
+		}
+		fmt.Printf("exported to %s\n", args[2])
+	}
+	fmt.Printf("this deletes all of %s's honks here and asks everyone else to forget them.\n", user.Name)
+	fmt.Printf("type the username again to confirm: ")
+	r := bufio.NewReader(os.Stdin)
+	answer, _ := r.ReadString('\n')
+	if strings.TrimSpace(answer) != user.Name {
+		errx("not deleting")
+	}
+	closeaccount(user, true)
+	fmt.Printf("deletes are queued and will be sent by the running server.\n")
+}
+
+func exporthandler(w http.ResponseWriter, r *http.Request) {
+	u := login.GetUserInfo(r)
+	user, _ := getUserBio(u.Username)
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"honk-%s.zip\"", user.Name))
+	err := exportarchive(user, w)
+	if err != nil {
+		elog.Printf("error exporting %s: %s", user.Name, err)
+	}
+}
+
+func deleteaccount(w http.ResponseWriter, r *http.Request) {
+	u := login.GetUserInfo(r)
+	user, _ := getUserBio(u.Username)
+	if r.FormValue("confirm") != user.Name || !checkpassword(user, r.FormValue("password")) {
+		http.Error(w, "not confirmed, account not deleted", http.StatusForbidden)
+		return
+	}
+	closeaccount(user, false)
+	http.SetCookie(w, &http.Cookie{
+		Name:     "auth",
+		Value:    "",
+		MaxAge:   -1,
+		Secure:   !develMode,
+		HttpOnly: true,
+	})
+	templinfo := getInfo(nil)
+	templinfo["ServerMessage"] = "your account is gone. goodbye."
+	err := readviews.Execute(w, "msg.html", templinfo)
+	if err != nil {
+		elog.Print(err)
+	}
+}
@@ -19,11 +19,12 @@ import (
 	"database/sql"
 	"os"
 	"strings"
+	"time"
 
 	"humungus.tedunangst.com/r/webs/htfilter"
 )
 
-var myVersion = 53 // index filehashes.xid
+var myVersion = 66 // hfcs hits
 
 type dbexecer interface {
 	Exec(query string, args ...interface{}) (sql.Result, error)
@@ -226,6 +227,103 @@ func upgradedb() {
 		setV(53)
 		fallthrough
 	case 53:
+		try("alter table auth add column name text")
+		try("alter table auth add column scopes text")
+		try("alter table auth add column created text")
+		try("alter table auth add column lastused text")
+		try("alter table auth add column deadline text")
+		setV(54)
+		fallthrough
+	case 54:
+		try("alter table auth add column ip text")
+		try("alter table auth add column agent text")
+		setV(55)
+		fallthrough
+	case 55:
+		try("create table passkeys (passkeyid integer primary key, userid integer, credid text, pubkey text, signcount integer, name text, created text, lastused text)")
+		try("create index idxpasskeys_userid on passkeys(userid)")
+		try("create index idxpasskeys_credid on passkeys(credid)")
+		try("create table recoverycodes (recoveryid integer primary key, userid integer, hash text)")
+		try("create index idxrecoverycodes_userid on recoverycodes(userid)")
+		setV(56)
+		fallthrough
+	case 56:
+		try("create table invites (inviteid integer primary key, userid integer, code text, created text, expiry text, maxuses integer, uses integer, approval integer)")
+		try("create index idxinvites_code on invites(code)")
+		try("alter table users add column userflags integer")
+		try("update users set userflags = 0")
+		// whoever set things up gets to run them
+		try("update users set userflags = ? where userid = ?", userIsAdmin, firstUserUID)
+		try("drop index if exists idxusers_username")
+		try("create unique index idxusers_username on users(username)")
+		setV(57)
+		fallthrough
+	case 57:
+		try("create table drafts (draftid integer primary key, userid integer, dt text, publish text, form text)")
+		try("create index idxdrafts_userid on drafts(userid)")
+		setV(58)
+		fallthrough
+	case 58:
+		try("create index idx_chonksuser on chonks(userid, target)")
+		setV(59)
+		fallthrough
+	case 59:
+		try("create table chatgroups (chatgroupid integer primary key, userid integer, target text, name text)")
+		try("create index idx_chatgroupsuser on chatgroups(userid)")
+		setV(60)
+		fallthrough
+	case 60:
+		try("create table chatpins (chatpinid integer primary key, userid integer, xid text, pubkey text, dt text)")
+		try("create index idx_chatpinsuser on chatpins(userid, xid)")
+		setV(61)
+		fallthrough
+	case 61:
+		try("create table spamsamples (sampleid integer primary key, userid integer, xid text, spam integer, words text, dt text)")
+		try("create index idx_spamsamplesuser on spamsamples(userid, xid)")
+		setV(62)
+		fallthrough
+	case 62:
+		try("create table notices (noticeid integer primary key, userid integer, what text, who text, xid text, content text, dt text, seen integer)")
+		try("create index idx_noticesuser on notices(userid, noticeid)")
+		setV(63)
+		fallthrough
+	case 63:
+		try("create table circles (circleid integer primary key, userid integer, name text, combos text, members text)")
+		try("create index idx_circlesuser on circles(userid)")
+		setV(64)
+		fallthrough
+	case 64:
+		try("alter table chonks add column newkey text")
+		try("update chonks set newkey = ''")
+		setV(65)
+		fallthrough
+	case 65:
+		try("alter table hfcs add column hits integer")
+		try("alter table hfcs add column lasthit text")
+		try("update hfcs set hits = 0, lasthit = ''")
+		rows := try("select hfcsid, json from hfcs")
+		type counted struct {
+			Hits    int64
+			LastHit time.Time
+		}
+		hits := make(map[int64]counted)
+		for rows.Next() {
+			var hfcsid int64
+			var j string
+			var c counted
+			err = rows.Scan(&hfcsid, &j)
+			checkErr(err)
+			if decodeJson(j, &c) == nil && c.Hits > 0 {
+				hits[hfcsid] = c
+			}
+		}
+		rows.Close()
+		for hfcsid, c := range hits {
+			try("update hfcs set hits = ?, lasthit = ? where hfcsid = ?", c.Hits, c.LastHit.UTC().Format(dbtimeformat), hfcsid)
+		}
+		setV(66)
+		fallthrough
+	case 66:
 		setcsrfkey()
 		try("analyze")
 		closedatabases()
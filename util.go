@@ -140,7 +140,7 @@ func initdb() {
 			return
 		}
 		setconfig("servername", addr)
-		err = createuser(db, namefield.Value, passfield.Value)
+		err = createuser(db, namefield.Value, passfield.Value, UserOptions{Admin: true})
 		if err != nil {
 			t2.Value += fmt.Sprintf("error: %s\n", err)
 			return
@@ -174,7 +174,7 @@ func simplesetup(db *sql.DB) {
 	fmt.Printf("password: ")
 	pass, err := r.ReadString('\n')
 	pass = pass[:len(pass)-1]
-	err = createuser(db, name, pass)
+	err = createuser(db, name, pass, UserOptions{Admin: true})
 	if err != nil {
 		elog.Print(err)
 		return
@@ -277,7 +277,7 @@ func adduser() {
 	app.Screen = termvc.NewScreen()
 	btn.Submit = func() {
 		t2.Value = ""
-		err := createuser(db, namefield.Value, passfield.Value)
+		err := createuser(db, namefield.Value, passfield.Value, UserOptions{})
 		if err != nil {
 			t2.Value += fmt.Sprintf("error: %s\n", err)
 			return
@@ -294,9 +294,13 @@ func deluser(username string) {
 		elog.Printf("no userfound")
 		return
 	}
-	userid := user.ID
 	db := opendatabase()
+	purgeuserdata(db, user.ID)
+	sqlMustQuery(db, "delete from users where userid = ?", user.ID)
+}
 
+// everything except the user row itself
+func purgeuserdata(db dbexecer, userid UserID) {
 	where := " where honkid in (select honkid from honks where userid = ?)"
 	sqlMustQuery(db, "delete from donks"+where, userid)
 	sqlMustQuery(db, "delete from onts"+where, userid)
@@ -311,7 +315,15 @@ func deluser(username string) {
 	sqlMustQuery(db, "delete from doovers where userid = ?", userid)
 	sqlMustQuery(db, "delete from hfcs where userid = ?", userid)
 	sqlMustQuery(db, "delete from auth where userid = ?", userid)
-	sqlMustQuery(db, "delete from users where userid = ?", userid)
+	sqlMustQuery(db, "delete from passkeys where userid = ?", userid)
+	sqlMustQuery(db, "delete from recoverycodes where userid = ?", userid)
+	sqlMustQuery(db, "delete from invites where userid = ?", userid)
+	sqlMustQuery(db, "delete from drafts where userid = ?", userid)
+	sqlMustQuery(db, "delete from chatgroups where userid = ?", userid)
+	sqlMustQuery(db, "delete from chatpins where userid = ?", userid)
+	sqlMustQuery(db, "delete from spamsamples where userid = ?", userid)
+	sqlMustQuery(db, "delete from notices where userid = ?", userid)
+	sqlMustQuery(db, "delete from circles where userid = ?", userid)
 }
 
 func chpass(username string) {
@@ -331,7 +343,7 @@ func chpass(username string) {
 	}
 }
 
-func createuser(db *sql.DB, name, pass string) error {
+func createuser(db *sql.DB, name, pass string, opts UserOptions) error {
 	if len(name) < 1 {
 		return fmt.Errorf("username is way too short")
 	}
@@ -361,13 +373,23 @@ func createuser(db *sql.DB, name, pass string) error {
 		return err
 	}
 	chatpubkey, chatseckey := newChatKeys()
-	var opts UserOptions
 	opts.ChatPubKey = tob64(chatpubkey.key[:])
 	opts.ChatSecKey = tob64(chatseckey.key[:])
 	jopt, _ := encodeJson(opts)
+	var flags int64
+	if opts.Admin {
+		flags |= userIsAdmin
+	}
+	if opts.Pending {
+		flags |= userIsPending
+	}
 	about := "what about me?"
-	_, err = db.Exec("insert into users (username, displayname, about, hash, pubkey, seckey, options) values (?, ?, ?, ?, ?, ?, ?)", name, name, about, hash, pubkey, seckey, jopt)
+	_, err = db.Exec("insert into users (username, displayname, about, hash, pubkey, seckey, options, userflags) values (?, ?, ?, ?, ?, ?, ?, ?)", name, name, about, hash, pubkey, seckey, jopt, flags)
 	if err != nil {
+		// two signups can both get past the check above
+		if sqerr, ok := err.(sqlite3.Error); ok && sqerr.ExtendedCode == sqlite3.ErrConstraintUnique {
+			return fmt.Errorf("user already exists")
+		}
 		return err
 	}
 	return nil
@@ -389,7 +411,7 @@ func createserveruser(db *sql.DB) error {
 	name := "server"
 	about := "server"
 	hash := "*"
-	_, err = db.Exec("insert into users (userid, username, displayname, about, hash, pubkey, seckey, options) values (?, ?, ?, ?, ?, ?, ?, ?)", serverUID, name, name, about, hash, pubkey, seckey, "")
+	_, err = db.Exec("insert into users (userid, username, displayname, about, hash, pubkey, seckey, options, userflags) values (?, ?, ?, ?, ?, ?, ?, ?, 0)", serverUID, name, name, about, hash, pubkey, seckey, "")
 	if err != nil {
 		return err
 	}
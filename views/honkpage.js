@@ -72,6 +72,7 @@ function flogit(el, how, xid) {
 	s += "d"
 	if (s == "untaged") s = "untagged"
 	if (s == "reacted") s = "badonked"
+	if (s == "notspamed") s = "not spam"
 	el.innerHTML = s
 	el.disabled = true
 	post("/zonkit", encode({"CSRF": csrftoken, "wherefore": how, "what": xid}))
@@ -354,6 +355,10 @@ function relinklinks() {
 			el.onclick = function() {
 				flogit(el, "untag", xid);
 			}
+		} else if (el.classList.contains("flogit-notspam")) {
+			el.onclick = function() {
+				flogit(el, "notspam", xid);
+			}
 		} else if (el.classList.contains("flogit-react")) {
 			el.onclick = function() {
 				flogit(el, "react", xid);
@@ -10,12 +10,108 @@ function updatedonker(el) {
 	el.children[1].textContent = el.children[0].value.slice(-20)
 }
 
+function tob64url(buf) {
+	var s = ""
+	var bytes = new Uint8Array(buf)
+	for (var i = 0; i < bytes.length; i++)
+		s += String.fromCharCode(bytes[i])
+	return btoa(s).replace(/\+/g, "-").replace(/\//g, "_").replace(/=+$/, "")
+}
+function fromb64url(s) {
+	s = atob(s.replace(/-/g, "+").replace(/_/g, "/"))
+	var bytes = new Uint8Array(s.length)
+	for (var i = 0; i < s.length; i++)
+		bytes[i] = s.charCodeAt(i)
+	return bytes.buffer
+}
+function passkeyrequest(method, url, data, whendone) {
+	var x = new XMLHttpRequest()
+	x.open(method, url)
+	x.timeout = 30 * 1000
+	x.responseType = "json"
+	x.setRequestHeader("Content-Type", "application/x-www-form-urlencoded")
+	x.onload = function() {
+		if (x.status != 200) {
+			alert("passkey trouble: " + x.status)
+			return
+		}
+		whendone(x.response)
+	}
+	x.send(data ? new URLSearchParams(data).toString() : null)
+}
+function addpasskey(el) {
+	var csrf = el.dataset.csrf
+	var name = document.getElementById("passkeyname").value
+	passkeyrequest("POST", "/passkeybegin", {"CSRF": csrf}, function(opts) {
+		opts.challenge = fromb64url(opts.challenge)
+		opts.user.id = fromb64url(opts.user.id)
+		opts.excludeCredentials = (opts.excludeCredentials || []).map(function(c) {
+			return { type: c.type, id: fromb64url(c.id) }
+		})
+		navigator.credentials.create({ publicKey: opts }).then(function(cred) {
+			var data = {
+				"CSRF": csrf,
+				"name": name,
+				"clientDataJSON": tob64url(cred.response.clientDataJSON),
+				"attestationObject": tob64url(cred.response.attestationObject),
+			}
+			passkeyrequest("POST", "/passkeyfinish", data, function(resp) {
+				if (resp && resp.recoverycodes) {
+					alert("recovery codes, save these now:\n" + resp.recoverycodes.join("\n"))
+				}
+				window.location.reload()
+			})
+		}).catch(function(e) { alert("passkey failed: " + e) })
+	})
+}
+function usepasskey(form) {
+	var username = form.querySelector("input[name=username]").value
+	var password = form.querySelector("input[name=password]").value
+	passkeyrequest("GET", "/passkeychallenge?username=" + encodeURIComponent(username), null, function(opts) {
+		opts.challenge = fromb64url(opts.challenge)
+		opts.allowCredentials = (opts.allowCredentials || []).map(function(c) {
+			return { type: c.type, id: fromb64url(c.id) }
+		})
+		if (!password) {
+			opts.userVerification = "required"
+		}
+		navigator.credentials.get({ publicKey: opts }).then(function(cred) {
+			var assertion = {
+				"id": cred.id,
+				"clientDataJSON": tob64url(cred.response.clientDataJSON),
+				"authenticatorData": tob64url(cred.response.authenticatorData),
+				"signature": tob64url(cred.response.signature),
+			}
+			form.querySelector("input[name=passkey]").value = JSON.stringify(assertion)
+			if (!password) {
+				form.action = "/passkeylogin"
+			}
+			form.submit()
+		}).catch(function(e) { alert("passkey failed: " + e) })
+	})
+}
+
 (function() {
 	var expand = document.querySelector("button.expand")
 	if (expand) {
 		expand.onclick = expandstuff
 	}
 
+	var passkeyer = document.getElementById("passkeyer")
+	if (passkeyer) {
+		passkeyer.onclick = function() {
+			usepasskey(this.form)
+			return false
+		}
+	}
+	var addpasskeyer = document.getElementById("addpasskey")
+	if (addpasskeyer) {
+		addpasskeyer.onclick = function() {
+			addpasskey(this)
+			return false
+		}
+	}
+
 	var donk = document.querySelector("#donker input")
 	if (donk) {
 		donk.onchange = function() {
@@ -111,6 +111,7 @@ func getInfo(r *http.Request) map[string]interface{} {
 		templinfo["UserStyle"] = getuserstyle(u)
 		combos, _ := combocache.Get(UserID(u.UserID))
 		templinfo["Combos"] = combos
+		templinfo["Circles"] = getcircles(UserID(u.UserID))
 	}
 	return templinfo
 }
@@ -121,7 +122,7 @@ var oldnews = gencache.New(gencache.Options[string, []byte]{
 		var honks []*ActivityPubActivity
 		var userid UserID = -1
 
-		templinfo["ServerMessage"] = serverMsg
+		templinfo["ServerMessage"] = live().ServerMsg
 		switch url {
 		case "/events":
 			honks = geteventhonks(userid)
@@ -162,7 +163,7 @@ func homepage(w http.ResponseWriter, r *http.Request) {
 	var honks []*ActivityPubActivity
 	var userid UserID = -1
 
-	templinfo["ServerMessage"] = serverMsg
+	templinfo["ServerMessage"] = live().ServerMsg
 	if u == nil || r.URL.Path == "/front" {
 		switch r.URL.Path {
 		case "/events":
@@ -200,6 +201,10 @@ func homepage(w http.ResponseWriter, r *http.Request) {
 			templinfo["ServerMessage"] = "saved honks"
 			templinfo["PageName"] = "saved"
 			honks = getsavedhonks(userid, 0)
+		case "/spam":
+			templinfo["ServerMessage"] = "possible spam, zonk it or say it's not"
+			templinfo["PageName"] = "spam"
+			honks = getspamhonks(userid)
 		default:
 			templinfo["PageName"] = "home"
 			honks = gethonksforuser(userid, 0)
@@ -495,7 +500,7 @@ func postinbox(w http.ResponseWriter, r *http.Request) {
 		keyname, err = httpsig.VerifyRequest(r, payload, getPubKey)
 	}
 	if err != nil {
-		ilog.Printf("inbox message failed signature for %s from %s: %s", keyname, r.Header.Get("X-Forwarded-For"), err)
+		ilog.Printf("inbox message failed signature for %s from %s: %s", keyname, requestaddr(r), err)
 		if keyname != "" {
 			ilog.Printf("bad signature from %s", keyname)
 		}
@@ -549,6 +554,12 @@ func postinbox(w http.ResponseWriter, r *http.Request) {
 			case "Service":
 				fallthrough
 			case "Person":
+				// only the chat key is of interest
+				if xid, _ := obj.GetString("id"); xid == who && originate(xid) == origin {
+					if chatkey, ok := obj.GetString(chatKeyProp); ok {
+						ingestchatkey(xid, chatkey)
+					}
+				}
 				return
 			case "Question":
 				return
@@ -585,6 +596,9 @@ func postinbox(w http.ResponseWriter, r *http.Request) {
 		obj, ok := j.GetString("object")
 		if ok {
 			log.Printf("%v obj was liked by %v - well done", obj, who)
+			if ourhonk(user, obj) {
+				notify(user, noticeReaction, who, obj, "")
+			}
 		}
 	default:
 		go saveandcheck(user, j, origin)
@@ -685,7 +699,7 @@ func serverinbox(w http.ResponseWriter, r *http.Request) {
 		keyname, err = httpsig.VerifyRequest(r, payload, getPubKey)
 	}
 	if err != nil {
-		ilog.Printf("inbox message failed signature for %s from %s: %s", keyname, r.Header.Get("X-Forwarded-For"), err)
+		ilog.Printf("inbox message failed signature for %s from %s: %s", keyname, requestaddr(r), err)
 		if keyname != "" {
 			ilog.Printf("bad signature from %s", keyname)
 		}
@@ -1518,7 +1532,12 @@ func showonehonk(w http.ResponseWriter, r *http.Request) {
 
 	if friendorfoe(r.Header.Get("Accept")) || wantjson {
 		j, ok := gimmejonk(xid)
-		if ok {
+		if j == nil {
+			if honk := getActivityPubActivity(user.ID, xid); honk != nil {
+				j, ok = circlejonk(user, r, honk)
+			}
+		}
+		if ok && j != nil {
 			trackback(xid, r)
 			w.Header().Set("Content-Type", ldjsonContentType)
 			w.Write(j)
@@ -1612,13 +1631,42 @@ func saveuser(w http.ResponseWriter, r *http.Request) {
 	options.SkinnyCSS = r.FormValue("skinny") == "skinny"
 	options.OmitImages = r.FormValue("omitimages") == "omitimages"
 	options.MentionAll = r.FormValue("mentionall") == "mentionall"
+	options.ManualFollows = r.FormValue("manualfollows") == "manualfollows"
 	options.InlineQuotes = r.FormValue("inlineqts") == "inlineqts"
+	options.ProxyMedia = r.FormValue("proxymedia") == "proxymedia"
 	options.MapLink = r.FormValue("maps")
 	options.Reaction = r.FormValue("reaction")
+	switch alt := r.FormValue("alttext"); alt {
+	case "warn", "require":
+		options.AltText = alt
+	default:
+		options.AltText = ""
+	}
+	options.ExpireDays, _ = strconv.ParseInt(r.FormValue("expiredays"), 10, 0)
+	if options.ExpireDays < 0 {
+		options.ExpireDays = 0
+	}
+	options.ExpireKeep = expirekeeps(r.FormValue("expirekeep"))
+	options.ExpireKeepReplied = r.FormValue("expirereplied") == "expirereplied"
+	options.ExpireKeepReacted = r.FormValue("expirereacted") == "expirereacted"
+	switch spam := r.FormValue("spamfilter"); spam {
+	case "collapse", "quarantine":
+		options.SpamFilter = spam
+	default:
+		options.SpamFilter = ""
+	}
+	var recoverycodes []string
 	enabletotp := r.FormValue("enabletotp") == "enabletotp"
 	if enabletotp {
 		if options.TOTP == "" {
 			options.TOTP = totp.NewSecret()
+			if countrecoverycodes(user.ID) == 0 {
+				codes, err := makerecoverycodes(user.ID)
+				if err != nil {
+					elog.Printf("error saving recovery codes: %s", err)
+				}
+				recoverycodes = codes
+			}
 		}
 	} else {
 		if options.TOTP != "" {
@@ -1626,7 +1674,7 @@ func saveuser(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	sendupdate := false
+	sendupdate := options.ManualFollows != user.Options.ManualFollows
 	ava := re_avatar.FindString(whatabout)
 	if ava != "" {
 		whatabout = re_avatar.ReplaceAllString(whatabout, "")
@@ -1668,10 +1716,20 @@ func saveuser(w http.ResponseWriter, r *http.Request) {
 	somenumberedusers.Clear(user.ID)
 	oldjonkers.Clear(u.Username)
 
+	if user.Options.ManualFollows && !options.ManualFollows {
+		// unlocked, so nobody needs to wait
+		for _, h := range getpendingdubs(user.ID) {
+			approvedub(user, h.ID, true)
+		}
+	}
 	if sendupdate {
 		updateMe(u.Username)
 	}
 
+	if recoverycodes != nil && err == nil {
+		showaccount(w, r, map[string]interface{}{"RecoveryCodes": recoverycodes})
+		return
+	}
 	http.Redirect(w, r, "/account", http.StatusSeeOther)
 }
 
@@ -1694,6 +1752,7 @@ func bonkit(xid string, user *WhatAbout) {
 	if err != nil {
 		elog.Printf("error acking bonk: %s", err)
 	}
+	spamsample(user, xonk, false)
 
 	oonker := xonk.Oonker
 	if oonker == "" {
@@ -1777,6 +1836,15 @@ func zonkit(w http.ResponseWriter, r *http.Request) {
 			if err != nil {
 				elog.Printf("error saving: %s", err)
 			}
+			spamsample(user, xonk, false)
+		}
+		return
+	}
+
+	if wherefore == "notspam" {
+		xonk := getActivityPubActivity(user.ID, what)
+		if xonk != nil {
+			notspam(user, xonk)
 		}
 		return
 	}
@@ -1864,6 +1932,7 @@ func zonkit(w http.ResponseWriter, r *http.Request) {
 			if err != nil {
 				elog.Printf("error untagging: %s", err)
 			}
+			spamsample(user, xonk, true)
 		}
 		var badparents map[string]bool
 		untagged.GetAndLock(user.ID, &badparents)
@@ -1876,10 +1945,8 @@ func zonkit(w http.ResponseWriter, r *http.Request) {
 	if wherefore == "zonk" {
 		xonk := getActivityPubActivity(user.ID, what)
 		if xonk != nil {
-			deletehonk(xonk.ID)
-			if xonk.Whofore == WhoPublic || xonk.Whofore == WhoPrivate {
-				sendzonkofsorts(xonk, user, "zonk", "")
-			}
+			spamsample(user, xonk, true)
+			zonkhonk(user, xonk)
 		}
 	}
 	_, err := stmtSaveZonker.Exec(user.ID, what, wherefore)
@@ -1889,6 +1956,14 @@ func zonkit(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// delete it here and, if it's ours, everywhere else
+func zonkhonk(user *WhatAbout, xonk *ActivityPubActivity) {
+	deletehonk(xonk.ID)
+	if xonk.Whofore == WhoPublic || xonk.Whofore == WhoPrivate {
+		sendzonkofsorts(xonk, user, "zonk", "")
+	}
+}
+
 func edithonkpage(w http.ResponseWriter, r *http.Request) {
 	u := login.GetUserInfo(r)
 	user, _ := getUserBio(u.Username)
@@ -1928,6 +2003,7 @@ func edithonkpage(w http.ResponseWriter, r *http.Request) {
 	templinfo["ServerMessage"] = "honk edit"
 	templinfo["IsPreview"] = true
 	templinfo["UpdateXID"] = honk.XID
+	templinfo["Circle"] = honk.Circle
 	if len(savedfiles) > 0 {
 		templinfo["SavedFile"] = strings.Join(savedfiles, ",")
 	}
@@ -1969,6 +2045,59 @@ func canedithonk(user *WhatAbout, honk *ActivityPubActivity) bool {
 	return true
 }
 
+// same rule as filters, for files that aren't loaded yet
+func missingalt(donks []*Donk) bool {
+	for _, d := range donks {
+		var f Donk
+		row := stmtGetFileDesc.QueryRow(d.FileID)
+		err := row.Scan(&f.Name, &f.Desc, &f.URL, &f.Media)
+		if err != nil {
+			elog.Printf("error getting file desc: %s", err)
+			continue
+		}
+		f.External = !strings.HasPrefix(f.URL, serverPrefix)
+		if undescribed(&f) {
+			return true
+		}
+	}
+	return false
+}
+
+func describedonk(w http.ResponseWriter, r *http.Request) {
+	u := login.GetUserInfo(r)
+	user, _ := getUserBio(u.Username)
+	xid := r.FormValue("xid")
+	honk := getActivityPubActivity(user.ID, xid)
+	if !canedithonk(user, honk) {
+		http.Error(w, "no editing that please", http.StatusInternalServerError)
+		return
+	}
+	donksforhonks([]*ActivityPubActivity{honk})
+	fileid, _ := strconv.ParseInt(r.FormValue("fileid"), 10, 0)
+	var donk *Donk
+	for _, d := range honk.Donks {
+		if d.FileID == fileid && d.Local {
+			donk = d
+		}
+	}
+	if donk == nil {
+		http.Error(w, "no such file", http.StatusNotFound)
+		return
+	}
+	desc := strings.TrimSpace(r.FormValue("desc"))
+	if desc != donk.Desc {
+		err := describehonkdonk(honk, donk, desc)
+		if err != nil {
+			http.Error(w, "error saving description", http.StatusInternalServerError)
+			return
+		}
+		oldjonks.Clear(honk.XID)
+		honk.What = "update"
+		go honkworldwide(user, honk)
+	}
+	http.Redirect(w, r, honk.XID, http.StatusSeeOther)
+}
+
 func submitdonk(w http.ResponseWriter, r *http.Request) ([]*Donk, error) {
 	if !strings.HasPrefix(strings.ToLower(r.Header.Get("Content-Type")), "multipart/form-data") {
 		return nil, nil
@@ -2017,6 +2146,18 @@ func formtodonk(w http.ResponseWriter, r *http.Request, filehdr *multipart.FileH
 			format = "svg"
 		}
 		name = make18CharRandomString() + "." + format
+	} else if av := avsniff(data); av != "" {
+		if len(data) > avformats[av].limit {
+			ilog.Printf("bad attachment: too much %s: %d", av, len(data))
+			http.Error(w, "didn't like your attachment", http.StatusUnsupportedMediaType)
+			return nil, err
+		}
+		avmeta(data, av, &donkmeta)
+		media = av
+		name = filehdr.Filename
+		if name == "" {
+			name = make18CharRandomString() + "." + avformats[av].ext
+		}
 	} else {
 		ct := http.DetectContentType(data)
 		switch ct {
@@ -2032,18 +2173,6 @@ func formtodonk(w http.ResponseWriter, r *http.Request, filehdr *multipart.FileH
 			if name == "" {
 				name = make18CharRandomString() + ".pdf"
 			}
-		case "video/mp4":
-			maxsize := 10000000
-			if len(data) > maxsize {
-				ilog.Printf("bad video: %s too much mp4: %d", err, len(data))
-				http.Error(w, "didn't like your MP4 attachment", http.StatusUnsupportedMediaType)
-				return nil, err
-			}
-			media = ct
-			name = filehdr.Filename
-			if name == "" {
-				name = make18CharRandomString() + ".mp4"
-			}
 		default:
 			maxsize := 100000
 			if len(data) > maxsize {
@@ -2066,6 +2195,14 @@ func formtodonk(w http.ResponseWriter, r *http.Request, filehdr *multipart.FileH
 		}
 	}
 	donkmeta.Length = len(data)
+	if u := login.GetUserInfo(r); u != nil {
+		user, _ := getUserBio(u.Username)
+		if user != nil && !roomforbytes(user, len(data)) {
+			ilog.Printf("%s is out of attachment space", user.Name)
+			http.Error(w, "out of space for attachments", http.StatusRequestEntityTooLarge)
+			return nil, errQuota
+		}
+	}
 	desc := strings.TrimSpace(r.FormValue("donkdesc"))
 	if desc == "" {
 		desc = name
@@ -2086,43 +2223,117 @@ func formtodonk(w http.ResponseWriter, r *http.Request, filehdr *multipart.FileH
 }
 
 func websubmithonk(w http.ResponseWriter, r *http.Request) {
+	if r.FormValue("preview") != "preview" && (r.FormValue("draft") == "draft" || strings.TrimSpace(r.FormValue("publishat")) != "") {
+		savedraft(w, r)
+		return
+	}
 	h := submithonk(w, r)
 	if h == nil {
 		return
 	}
+	if draftid, _ := strconv.ParseInt(r.FormValue("draftid"), 10, 0); draftid != 0 {
+		deletedraft(h.UserID, draftid)
+	}
 	redir := h.XID[len(serverURL("")):]
 	http.Redirect(w, r, redir, http.StatusSeeOther)
 }
 
-// what a hot mess this function is
 func submithonk(w http.ResponseWriter, r *http.Request) *ActivityPubActivity {
-	rid := r.FormValue("rid")
-	noise := r.FormValue("noise")
-	format := r.FormValue("format")
+	return submithonkas(w, r, login.GetUserInfo(r))
+}
+
+// what a hot mess this function is
+func submithonkas(w http.ResponseWriter, r *http.Request, u *login.UserInfo) *ActivityPubActivity {
+	user, _ := getUserBio(u.Username)
+
+	var uploads []*Donk
+	if r.FormValue("donkxid") == "" {
+		donks, err := submitdonk(w, r)
+		if err != nil && err != http.ErrMissingFile {
+			return nil
+		}
+		uploads = donks
+	}
+	honk, donkxid, err := composehonk(user, r.Form, uploads)
+	if err != nil {
+		if herr, ok := err.(*honkerror); ok {
+			http.Error(w, herr.msg, herr.code)
+		} else {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		}
+		return nil
+	}
+
+	preview := r.FormValue("preview") == "preview"
+	servermsg := "honk preview"
+	noalt := false
+	if msg, overridable := altproblem(user, honk.Donks); msg != "" {
+		if !overridable || r.FormValue("noalt") != "noalt" {
+			// api clients can't do anything with a preview page
+			if r.URL.Path == "/api" {
+				if overridable {
+					msg = "images have no description, send noalt to post anyway"
+				}
+				http.Error(w, msg, http.StatusBadRequest)
+				return nil
+			}
+			preview = true
+			noalt = overridable
+			servermsg = msg
+		}
+	}
+	if preview {
+		previewhonk(w, r, user, honk, donkxid, servermsg, noalt)
+		return nil
+	}
+
+	err = posthonk(user, honk)
+	if err != nil {
+		http.Error(w, "error saving honk", http.StatusInternalServerError)
+		return nil
+	}
+	return honk
+}
+
+// a compose failure and how to report it over http
+type honkerror struct {
+	code int
+	msg  string
+}
+
+func (e *honkerror) Error() string {
+	return e.msg
+}
+
+// build a honk from the compose form, without saving anything but
+// pending attachment descriptions. uploads were already saved.
+// returns the honk and the attachment ids for the form.
+func composehonk(user *WhatAbout, form url.Values, uploads []*Donk) (*ActivityPubActivity, string, error) {
+	rid := form.Get("rid")
+	noise := form.Get("noise")
+	format := form.Get("format")
 	if format == "" {
 		format = "markdown"
 	}
 	if !(format == "markdown" || format == "html") {
-		http.Error(w, "unknown format", 500)
-		return nil
+		return nil, "", &honkerror{http.StatusInternalServerError, "unknown format"}
 	}
 
-	u := login.GetUserInfo(r)
-	user, _ := getUserBio(u.Username)
-
 	dt := time.Now().UTC()
-	updatexid := r.FormValue("updatexid")
+	updatexid := form.Get("updatexid")
 	var honk *ActivityPubActivity
 	if updatexid != "" {
 		honk = getActivityPubActivity(user.ID, updatexid)
 		if !canedithonk(user, honk) {
-			http.Error(w, "no editing that please", http.StatusInternalServerError)
-			return nil
+			return nil, "", &honkerror{http.StatusInternalServerError, "no editing that please"}
 		}
 		honk.Date = dt
 		honk.What = "update"
 		honk.Format = format
 	} else {
+		if !roomforhonk(user) {
+			return nil, "", &honkerror{http.StatusTooManyRequests, "too many honks, try again later"}
+		}
 		xid := fmt.Sprintf("%s/%s/%s", user.URL, honkSep, make18CharRandomString())
 		what := "honk"
 		honk = &ActivityPubActivity{
@@ -2135,10 +2346,10 @@ func submithonk(w http.ResponseWriter, r *http.Request) *ActivityPubActivity {
 			Format:   format,
 		}
 	}
-	honk.SeeAlso = strings.TrimSpace(r.FormValue("seealso"))
-	honk.Onties = strings.TrimSpace(r.FormValue("onties"))
-	honk.Link = strings.TrimSpace(r.FormValue("link"))
-	honk.LegalName = strings.TrimSpace(r.FormValue("legalname"))
+	honk.SeeAlso = strings.TrimSpace(form.Get("seealso"))
+	honk.Onties = strings.TrimSpace(form.Get("onties"))
+	honk.Link = strings.TrimSpace(form.Get("link"))
+	honk.LegalName = strings.TrimSpace(form.Get("legalname"))
 
 	var convoy string
 	noise = strings.Replace(noise, "\r", "", -1)
@@ -2161,8 +2372,10 @@ func submithonk(w http.ResponseWriter, r *http.Request) *ActivityPubActivity {
 	if rid != "" {
 		xonk := getActivityPubActivity(user.ID, rid)
 		if xonk == nil {
-			http.Error(w, "replyto disappeared", http.StatusNotFound)
-			return nil
+			return nil, "", &honkerror{http.StatusNotFound, "replyto disappeared"}
+		}
+		if updatexid == "" {
+			spamsample(user, xonk, false)
 		}
 		if xonk.Public {
 			honk.Audience = append(honk.Audience, xonk.Audience...)
@@ -2198,19 +2411,14 @@ func submithonk(w http.ResponseWriter, r *http.Request) *ActivityPubActivity {
 	honk.Audience = stringArrayTrimUntilDupe(honk.Audience)
 	if len(honk.Audience) == 0 {
 		ilog.Printf("honk to nowhere")
-		http.Error(w, "honk to nowhere...", http.StatusNotFound)
-		return nil
+		return nil, "", &honkerror{http.StatusNotFound, "honk to nowhere..."}
 	}
 	honk.Public = loudandproud(honk.Audience)
 
-	donkxid := strings.Join(r.Form["donkxid"], ",")
+	donkxid := strings.Join(form["donkxid"], ",")
 	if donkxid == "" {
-		donks, err := submitdonk(w, r)
-		if err != nil && err != http.ErrMissingFile {
-			return nil
-		}
-		if len(donks) > 0 {
-			honk.Donks = append(honk.Donks, donks...)
+		if len(uploads) > 0 {
+			honk.Donks = append(honk.Donks, uploads...)
 			var xids []string
 			for _, d := range honk.Donks {
 				xids = append(xids, fmt.Sprintf("%s:%d", d.XID, d.FileID))
@@ -2219,6 +2427,7 @@ func submithonk(w http.ResponseWriter, r *http.Request) *ActivityPubActivity {
 		}
 	} else {
 		xids := strings.Split(donkxid, ",")
+		desc := strings.TrimSpace(form.Get("donkdesc"))
 		for i, xid := range xids {
 			if i > 16 {
 				break
@@ -2234,6 +2443,15 @@ func submithonk(w http.ResponseWriter, r *http.Request) *ActivityPubActivity {
 				donk = finddonk(url)
 			}
 			if donk != nil {
+				if desc != "" && len(xids) == 1 && donk.Desc != desc {
+					// still pending, so the description can be fixed up
+					res, err := stmtSetPendingFileDesc.Exec(desc, donk.FileID)
+					if err == nil {
+						if n, _ := res.RowsAffected(); n == 1 {
+							donk.Desc = desc
+						}
+					}
+				}
 				honk.Donks = append(honk.Donks, donk)
 			} else {
 				ilog.Printf("can't find file: %s", xid)
@@ -2243,10 +2461,10 @@ func submithonk(w http.ResponseWriter, r *http.Request) *ActivityPubActivity {
 	memetize(honk)
 	imaginate(honk)
 
-	placename := strings.TrimSpace(r.FormValue("placename"))
-	placelat := strings.TrimSpace(r.FormValue("placelat"))
-	placelong := strings.TrimSpace(r.FormValue("placelong"))
-	placeurl := strings.TrimSpace(r.FormValue("placeurl"))
+	placename := strings.TrimSpace(form.Get("placename"))
+	placelat := strings.TrimSpace(form.Get("placelat"))
+	placelong := strings.TrimSpace(form.Get("placelong"))
+	placeurl := strings.TrimSpace(form.Get("placeurl"))
 	if placename != "" || placelat != "" || placelong != "" || placeurl != "" {
 		p := new(Place)
 		p.Name = placename
@@ -2255,7 +2473,7 @@ func submithonk(w http.ResponseWriter, r *http.Request) *ActivityPubActivity {
 		p.Url = placeurl
 		honk.Place = p
 	}
-	timestart := strings.TrimSpace(r.FormValue("timestart"))
+	timestart := strings.TrimSpace(form.Get("timestart"))
 	if timestart != "" {
 		t := new(Time)
 		now := time.Now().Local()
@@ -2269,7 +2487,7 @@ func submithonk(w http.ResponseWriter, r *http.Request) *ActivityPubActivity {
 				break
 			}
 		}
-		timeend := r.FormValue("timeend")
+		timeend := form.Get("timeend")
 		dur := parseDuration(timeend)
 		if dur != 0 {
 			t.Duration = Duration(dur)
@@ -2281,12 +2499,19 @@ func submithonk(w http.ResponseWriter, r *http.Request) *ActivityPubActivity {
 	}
 	if honk.Public {
 		// fuck it, the form has the final say
-		honk.Public = r.FormValue("privacy") != "on"
+		honk.Public = form.Get("privacy") != "on"
 		if !honk.Public {
 			honk.Audience = []string{user.URL, user.URL + "/followers"}
 		}
 	}
 
+	if circle := form.Get("circle"); circle != "" {
+		err := circlehonk(user, honk, circle)
+		if err != nil {
+			return nil, "", &honkerror{http.StatusBadRequest, err.Error()}
+		}
+	}
+
 	if honk.Public {
 		honk.Whofore = WhoPublic
 	} else {
@@ -2296,55 +2521,82 @@ func submithonk(w http.ResponseWriter, r *http.Request) *ActivityPubActivity {
 	// back to markdown
 	honk.Noise = noise
 
-	if r.FormValue("preview") == "preview" {
-		honks := []*ActivityPubActivity{honk}
-		reverbolate(user.ID, honks)
-		templinfo := getInfo(r)
-		templinfo["HonkCSRF"] = login.GetCSRF("honkhonk", r)
-		templinfo["Honks"] = honks
-		templinfo["MapLink"] = getmaplink(u)
-		templinfo["InReplyTo"] = r.FormValue("rid")
-		templinfo["Noise"] = r.FormValue("noise")
-		templinfo["Onties"] = honk.Onties
-		templinfo["SeeAlso"] = honk.SeeAlso
-		templinfo["Link"] = honk.Link
-		templinfo["LegalName"] = honk.LegalName
-		templinfo["SavedFile"] = donkxid
-		if tm := honk.Time; tm != nil {
-			templinfo["ShowTime"] = " "
-			templinfo["StartTime"] = tm.StartTime.Format("2006-01-02 15:04")
-			if tm.Duration != 0 {
-				templinfo["Duration"] = tm.Duration
-			}
-		}
-		templinfo["IsPreview"] = true
-		templinfo["UpdateXID"] = updatexid
-		templinfo["ServerMessage"] = "honk preview"
-		err := readviews.Execute(w, "honkpage.html", templinfo)
-		if err != nil {
-			elog.Print(err)
+	return honk, donkxid, nil
+}
+
+// what the user's alt text policy has to say about a honk.
+// a warning can be overridden with noalt, a requirement can't.
+func altproblem(user *WhatAbout, donks []*Donk) (string, bool) {
+	policy := user.Options.AltText
+	if policy == "" || !missingalt(donks) {
+		return "", false
+	}
+	if policy == "require" {
+		return "images need a description before posting", false
+	}
+	return "images have no description, post again to send anyway", true
+}
+
+func previewhonk(w http.ResponseWriter, r *http.Request, user *WhatAbout, honk *ActivityPubActivity, donkxid string, servermsg string, noalt bool) {
+	honks := []*ActivityPubActivity{honk}
+	reverbolate(user.ID, honks)
+	templinfo := getInfo(r)
+	templinfo["HonkCSRF"] = login.GetCSRF("honkhonk", r)
+	templinfo["Honks"] = honks
+	templinfo["MapLink"] = getmaplink(login.GetUserInfo(r))
+	templinfo["InReplyTo"] = r.FormValue("rid")
+	templinfo["Noise"] = r.FormValue("noise")
+	templinfo["Onties"] = honk.Onties
+	templinfo["SeeAlso"] = honk.SeeAlso
+	templinfo["Link"] = honk.Link
+	templinfo["LegalName"] = honk.LegalName
+	templinfo["SavedFile"] = donkxid
+	if tm := honk.Time; tm != nil {
+		templinfo["ShowTime"] = " "
+		templinfo["StartTime"] = tm.StartTime.Format("2006-01-02 15:04")
+		if tm.Duration != 0 {
+			templinfo["Duration"] = tm.Duration
 		}
-		return nil
 	}
+	templinfo["IsPreview"] = true
+	templinfo["UpdateXID"] = r.FormValue("updatexid")
+	templinfo["ServerMessage"] = servermsg
+	templinfo["NoAlt"] = noalt
+	templinfo["DraftID"] = r.FormValue("draftid")
+	templinfo["PublishAt"] = r.FormValue("publishat")
+	templinfo["Private"] = r.FormValue("privacy") == "on"
+	templinfo["Circle"] = r.FormValue("circle")
+	if len(honk.Donks) == 1 {
+		templinfo["DonkDesc"] = honk.Donks[0].Desc
+	}
+	err := readviews.Execute(w, "honkpage.html", templinfo)
+	if err != nil {
+		elog.Print(err)
+	}
+}
 
-	if updatexid != "" {
-		updatehonk(honk)
+// save a composed honk and send it out
+func posthonk(user *WhatAbout, honk *ActivityPubActivity) error {
+	var err error
+	if honk.ID != 0 {
+		err = updatehonk(honk)
 		oldjonks.Clear(honk.XID)
 	} else {
-		err := savehonk(honk)
-		if err != nil {
-			elog.Printf("uh oh")
-			return nil
-		}
+		err = savehonk(honk)
+	}
+	if err != nil {
+		elog.Printf("error saving honk: %s", err)
+		return err
 	}
 
 	// reload for consistency
 	honk.Donks = nil
 	donksforhonks([]*ActivityPubActivity{honk})
+	go cardify(honk)
 
 	go honkworldwide(user, honk)
 
-	return honk
+	return nil
 }
 
 func firstRune(s string) rune { r, _ := utf8.DecodeRuneInString(s); return r }
@@ -2364,6 +2616,7 @@ func showhonkers(w http.ResponseWriter, r *http.Request) {
 	templinfo["FirstRune"] = firstRune
 	templinfo["Letters"] = letters
 	templinfo["Honkers"] = honkers
+	templinfo["Pending"] = getpendingdubs(userid)
 	templinfo["HonkerCSRF"] = login.GetCSRF("submithonker", r)
 	err := readviews.Execute(w, "honkers.html", templinfo)
 	if err != nil {
@@ -2374,16 +2627,23 @@ func showhonkers(w http.ResponseWriter, r *http.Request) {
 func showchatter(w http.ResponseWriter, r *http.Request) {
 	u := login.GetUserInfo(r)
 	chatnewnone(UserID(u.UserID))
-	chatter := loadchatter(UserID(u.UserID), 0)
+	if r.FormValue("target") != "" || r.FormValue("q") != "" || r.FormValue("before") != "" || r.FormValue("archived") != "" {
+		showchathistory(w, r)
+		return
+	}
+	user, _ := getUserBio(u.Username)
+	chatter := loadchatter(user.ID, 0)
 	for _, chat := range chatter {
 		for _, ch := range chat.Chonks {
 			filterchonk(ch)
 		}
 	}
+	chatterkeys(user, chatter)
 
 	templinfo := getInfo(r)
 	templinfo["Chatter"] = chatter
 	templinfo["ChonkCSRF"] = login.GetCSRF("sendchonk", r)
+	templinfo["ChatFingerprint"] = chatfingerprint(user.ChatPubKey)
 	err := readviews.Execute(w, "chatter.html", templinfo)
 	if err != nil {
 		elog.Print(err)
@@ -2399,9 +2659,12 @@ func submitchonk(w http.ResponseWriter, r *http.Request) {
 	dt := time.Now().UTC()
 	xid := fmt.Sprintf("%s/%s/%s", user.URL, "chonk", make18CharRandomString())
 
-	if !strings.HasPrefix(target, "https://") {
-		target = fullname(target, user.ID)
+	members, err := resolvemembers(user, target)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
 	}
+	target = chattarget(user, members)
 	if target == "" {
 		http.Error(w, "who is that?", http.StatusInternalServerError)
 		return
@@ -2465,6 +2728,17 @@ func websubmithonker(w http.ResponseWriter, r *http.Request) {
 	http.Redirect(w, r, "/honkers", http.StatusSeeOther)
 }
 
+func dubaction(w http.ResponseWriter, r *http.Request) {
+	u := login.GetUserInfo(r)
+	user, _ := getUserBio(u.Username)
+	honkerid, _ := strconv.ParseInt(r.FormValue("honkerid"), 10, 0)
+	err := approvedub(user, honkerid, r.FormValue("action") == "accept")
+	if err != nil {
+		elog.Printf("error answering follow request: %s", err)
+	}
+	http.Redirect(w, r, "/honkers", http.StatusSeeOther)
+}
+
 func submithonker(w http.ResponseWriter, r *http.Request) *Honker {
 	u := login.GetUserInfo(r)
 	user, _ := getUserBio(u.Username)
@@ -2543,6 +2817,9 @@ func hfcspage(w http.ResponseWriter, r *http.Request) {
 	templinfo := getInfo(r)
 	templinfo["Filters"] = filters
 	templinfo["FilterCSRF"] = login.GetCSRF("filter", r)
+	templinfo["Draft"] = new(Filter)
+	user, _ := getUserBio(userinfo.Username)
+	templinfo["FilterLists"] = filterlists(user)
 	err := readviews.Execute(w, "hfcs.html", templinfo)
 	if err != nil {
 		elog.Print(err)
@@ -2550,12 +2827,42 @@ func hfcspage(w http.ResponseWriter, r *http.Request) {
 }
 
 func accountpage(w http.ResponseWriter, r *http.Request) {
+	showaccount(w, r, nil)
+}
+
+// extras are things to show only once
+func showaccount(w http.ResponseWriter, r *http.Request, extras map[string]interface{}) {
 	u := login.GetUserInfo(r)
 	user, _ := getUserBio(u.Username)
 	templinfo := getInfo(r)
 	templinfo["UserCSRF"] = login.GetCSRF("saveuser", r)
 	templinfo["LogoutCSRF"] = login.GetCSRF("logout", r)
 	templinfo["User"] = user
+	templinfo["TokenCSRF"] = login.GetCSRF("apptoken", r)
+	auths := getauths(user.ID)
+	current := currentauth(r)
+	var sessions, tokens []*AuthEntry
+	for _, a := range auths {
+		a.Current = a.ID == current
+		if a.App {
+			tokens = append(tokens, a)
+		} else {
+			sessions = append(sessions, a)
+		}
+	}
+	templinfo["Sessions"] = sessions
+	templinfo["AppTokens"] = tokens
+	templinfo["AppScopes"] = appScopes
+	templinfo["PasskeyCSRF"] = login.GetCSRF("passkey", r)
+	templinfo["Passkeys"] = getpasskeys(user.ID)
+	templinfo["RecoveryCodesLeft"] = countrecoverycodes(user.ID)
+	templinfo["Quota"] = getquota(user)
+	templinfo["DeleteCSRF"] = login.GetCSRF("deleteaccount", r)
+	templinfo["ChatKeyCSRF"] = login.GetCSRF("rotatechatkey", r)
+	templinfo["ChatFingerprint"] = chatfingerprint(user.ChatPubKey)
+	for k, v := range extras {
+		templinfo[k] = v
+	}
 	about := user.About
 	if ava := user.Options.Avatar; ava != "" {
 		about += "\n\navatar: " + ava[strings.LastIndexByte(ava, '/')+1:]
@@ -2575,10 +2882,13 @@ func dochpass(w http.ResponseWriter, r *http.Request) {
 	if err != nil {
 		elog.Printf("error changing password: %s", err)
 	}
+	// everything else is gone now
+	authcache.Flush()
 	http.Redirect(w, r, "/account", http.StatusSeeOther)
 }
 
-var oldfingers = gencache.New(gencache.Options[string, []byte]{Fill: func(orig string) ([]byte, bool) {
+// the local user name for a webfinger resource
+func fingername(orig string) string {
 	if strings.HasPrefix(orig, "acct:") {
 		orig = orig[5:]
 	}
@@ -2600,8 +2910,12 @@ var oldfingers = gencache.New(gencache.Options[string, []byte]{Fill: func(orig s
 			}
 		}
 	}
-	user, err := getUserBio(name)
-	if err != nil {
+	return name
+}
+
+var oldfingers = gencache.New(gencache.Options[string, []byte]{Fill: func(orig string) ([]byte, bool) {
+	user, err := getUserBio(fingername(orig))
+	if err != nil || suspended(user) {
 		return nil, false
 	}
 
@@ -2636,6 +2950,8 @@ func fingerlicker(w http.ResponseWriter, r *http.Request) {
 	if ok {
 		w.Header().Set("Content-Type", "application/jrd+json")
 		w.Write(j)
+	} else if user, err := getUserBio(fingername(orig)); err == nil && user.Options.Deleted {
+		http.Error(w, "gone", http.StatusGone)
 	} else {
 		http.NotFound(w, r)
 	}
@@ -2662,7 +2978,7 @@ func lookatme(ava string) string {
 
 func avatate(w http.ResponseWriter, r *http.Request) {
 	if develMode {
-		loadAvatarColors()
+		reloadconfig()
 	}
 	n := r.FormValue("a")
 	forceHexAva := r.FormValue("hex") == "1"
@@ -2681,6 +2997,7 @@ func avatate(w http.ResponseWriter, r *http.Request) {
 		if time.Since(s.ModTime()) < (time.Hour * 24 * 7) { // Expire the cache
 			b, _ := os.ReadFile(fileKey)
 			w.Header().Set("Content-Type", http.DetectContentType(b))
+			w.Header().Set("Content-Security-Policy", "sandbox")
 			w.Write(b)
 			return
 		}
@@ -2706,12 +3023,13 @@ func avatate(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	imageBytes, err := fetchsome(info.AvatarURL)
-	if err != nil {
+	imageBytes, media, err := proxyfetch(info.AvatarURL, "lil")
+	if err != nil || imageBytes == nil {
 		easyAvatar(r, n, w)
 		return
 	}
-	w.Header().Set("Content-Type", http.DetectContentType(imageBytes))
+	w.Header().Set("Content-Type", media)
+	w.Header().Set("Content-Security-Policy", "sandbox")
 	w.Write(imageBytes)
 
 	go func() {
@@ -2755,8 +3073,8 @@ func servehelp(w http.ResponseWriter, r *http.Request) {
 func servehtml(w http.ResponseWriter, r *http.Request) {
 	u := login.GetUserInfo(r)
 	templinfo := getInfo(r)
-	templinfo["AboutMsg"] = aboutMsg
-	templinfo["LoginMsg"] = loginMsg
+	templinfo["AboutMsg"] = live().AboutMsg
+	templinfo["LoginMsg"] = live().LoginMsg
 	templinfo["HonkVersion"] = softwareVersion
 	if r.URL.Path == "/about" {
 		templinfo["Sensors"] = getSensors()
@@ -2857,7 +3175,7 @@ func webhydra(w http.ResponseWriter, r *http.Request) {
 	case "home":
 		honks = gethonksforuser(userid, wanted)
 		honks = osmosis(honks, userid, true)
-		hydra.Srvmsg = serverMsg
+		hydra.Srvmsg = live().ServerMsg
 	case "first":
 		honks = gethonksforuserfirstclass(userid, wanted)
 		honks = osmosis(honks, userid, true)
@@ -2944,6 +3262,10 @@ func apihandler(w http.ResponseWriter, r *http.Request) {
 	action := r.FormValue("action")
 	wait, _ := strconv.ParseInt(r.FormValue("wait"), 10, 0)
 	dlog.Printf("api request '%s' on behalf of %s", action, u.Username)
+	if !tokenallows(r, apiScopes[action]) {
+		http.Error(w, "token not allowed to "+action, http.StatusForbidden)
+		return
+	}
 	switch action {
 	case "honk":
 		h := submithonk(w, r)
@@ -3045,9 +3367,16 @@ func apihandler(w http.ResponseWriter, r *http.Request) {
 		fmt.Fprintf(w, "%d", h.ID)
 	case "getchatter":
 		wanted, _ := strconv.ParseInt(r.FormValue("after"), 10, 0)
+		before, _ := strconv.ParseInt(r.FormValue("before"), 10, 0)
 		chatnewnone(UserID(u.UserID))
 		user, _ := getUserBio(u.Username)
-		chatter := loadchatter(UserID(u.UserID), wanted)
+		target := chattarget(user, strings.Fields(r.FormValue("target")))
+		var chatter []*Chatter
+		if target != "" || before != 0 {
+			chatter = loadchathistory(UserID(u.UserID), target, "", before)
+		} else {
+			chatter = loadchatter(UserID(u.UserID), wanted)
+		}
 		for _, chat := range chatter {
 			for _, ch := range chat.Chonks {
 				filterchonk(ch)
@@ -3058,6 +3387,17 @@ func apihandler(w http.ResponseWriter, r *http.Request) {
 		j["mecount"] = user.Options.MeCount
 		j["chatcount"] = user.Options.ChatCount
 		j.Write(w)
+	case "getnotices":
+		after, _ := strconv.ParseInt(r.FormValue("after"), 10, 0)
+		user, _ := getUserBio(u.Username)
+		j := junk.New()
+		j["notices"] = getnotices(user, after)
+		j["noticecount"] = user.Options.NoticeCount
+		j.Write(w)
+	case "readnotices":
+		upto, _ := strconv.ParseInt(r.FormValue("upto"), 10, 0)
+		user, _ := getUserBio(u.Username)
+		readnotices(user, upto)
 	default:
 		http.Error(w, "unknown action", http.StatusNotFound)
 		return
@@ -3223,14 +3563,23 @@ func startWatcher() {
 
 func doubleCheck(username string, r *http.Request) bool {
 	user, err := getUserBio(username)
-	if err != nil {
+	if err != nil || suspended(user) {
 		return false
 	}
+	haspasskeys := len(getpasskeys(user.ID)) > 0
+	if user.Options.TOTP == "" && !haspasskeys {
+		return true
+	}
+	if haspasskeys && passkeysecond(user, r) {
+		return true
+	}
 	if user.Options.TOTP != "" {
-		code, _ := strconv.Atoi(r.FormValue("totpcode"))
-		return totp.CheckCode(user.Options.TOTP, code)
+		code, err := strconv.Atoi(r.FormValue("totpcode"))
+		if err == nil && totp.CheckCode(user.Options.TOTP, code) {
+			return true
+		}
 	}
-	return true
+	return userecoverycode(user, r.FormValue("totpcode"), r.FormValue("password"))
 }
 
 func serve() {
@@ -3246,11 +3595,16 @@ func serve() {
 	runBackendServer()
 	go enditall()
 	go redeliverator()
+	go expirator()
+	go filtcounter()
+	go filterlistfetcher()
+	go draftsman()
+	go spamtrainer()
 	go tracker()
 	go syndicator()
 	go bgmonitor()
 	go qotd()
-	loadLingo()
+	go proxysweeper()
 	extractViewsToTmpDir()
 	emuinit()
 
@@ -3276,7 +3630,6 @@ func serve() {
 		for _, s := range assets {
 			savedassetparams[s] = getassetparam(s)
 		}
-		loadAvatarColors()
 	}
 	startWatcher()
 
@@ -3286,6 +3639,8 @@ func serve() {
 	mux.Use(addcspheaders)
 	mux.NotFoundHandler = http.HandlerFunc(serveStaticSiteInstead)
 	mux.Use(login.Checker)
+	mux.Use(authguard)
+	mux.Use(suspendguard)
 
 	mux.Handle("/api", login.TokenRequired(http.HandlerFunc(apihandler)))
 
@@ -3306,9 +3661,9 @@ func serve() {
 	GetSubrouter.HandleFunc("/"+userSep+"/{name:[\\pL[:digit:]]+}/"+honkSep+"/{xid:[\\pL[:digit:]]+}.json", showonehonk)
 	GetSubrouter.HandleFunc("/"+userSep+"/{name:[\\pL[:digit:]]+}/rss", showrss)
 	PostSubRouter.HandleFunc("/"+userSep+"/{name:[\\pL[:digit:]]+}/inbox", postinbox)
-	GetSubrouter.Handle("/"+userSep+"/{name:[\\pL[:digit:]]+}/inbox", login.TokenRequired(http.HandlerFunc(getinbox)))
+	GetSubrouter.Handle("/"+userSep+"/{name:[\\pL[:digit:]]+}/inbox", login.TokenRequired(scopeRequired("read", http.HandlerFunc(getinbox))))
 	GetSubrouter.HandleFunc("/"+userSep+"/{name:[\\pL[:digit:]]+}/outbox", getoutbox)
-	PostSubRouter.Handle("/"+userSep+"/{name:[\\pL[:digit:]]+}/outbox", login.TokenRequired(http.HandlerFunc(postoutbox)))
+	PostSubRouter.Handle("/"+userSep+"/{name:[\\pL[:digit:]]+}/outbox", login.TokenRequired(scopeRequired("raw", http.HandlerFunc(postoutbox))))
 	GetSubrouter.HandleFunc("/"+userSep+"/{name:[\\pL[:digit:]]+}/followers", emptiness)
 	GetSubrouter.HandleFunc("/"+userSep+"/{name:[\\pL[:digit:]]+}/following", emptiness)
 	GetSubrouter.HandleFunc("/a", avatate)
@@ -3341,18 +3696,44 @@ func serve() {
 	GetSubrouter.HandleFunc("/about", servehtml)
 	GetSubrouter.HandleFunc("/login", servehtml)
 	PostSubRouter.HandleFunc("/dologin", login.LoginFunc)
-	GetSubrouter.HandleFunc("/logout", login.LogoutFunc)
+	GetSubrouter.HandleFunc("/signup", signuppage)
+	PostSubRouter.HandleFunc("/dosignup", dosignup)
+	GetSubrouter.HandleFunc("/passkeychallenge", passkeychallenge)
+	PostSubRouter.HandleFunc("/passkeylogin", passkeylogin)
+	GetSubrouter.HandleFunc("/logout", logout)
 	GetSubrouter.HandleFunc("/help/{name:[\\pL[:digit:]_.-]+}", servehelp)
 
 	LoggedInRouter := mux.NewRoute().Subrouter()
 	LoggedInRouter.Use(login.Required)
 	LoggedInRouter.HandleFunc("/first", homepage)
 	LoggedInRouter.HandleFunc("/chatter", showchatter)
+	LoggedInRouter.HandleFunc("/edits", showrevisions)
 	LoggedInRouter.Handle("/sendchonk", login.CSRFWrap("sendchonk", http.HandlerFunc(submitchonk)))
+	LoggedInRouter.Handle("/chataction", login.CSRFWrap("sendchonk", http.HandlerFunc(chataction)))
+	LoggedInRouter.Handle("/rotatechatkey", login.CSRFWrap("rotatechatkey", http.HandlerFunc(rotatechatkey)))
 	LoggedInRouter.HandleFunc("/saved", homepage)
+	LoggedInRouter.HandleFunc("/spam", homepage)
+	LoggedInRouter.HandleFunc("/notices", shownotices)
+	LoggedInRouter.Handle("/noticeprefs", login.CSRFWrap("notices", http.HandlerFunc(savenoticeprefs)))
+	LoggedInRouter.HandleFunc("/circles", showcircles)
+	LoggedInRouter.Handle("/circleaction", login.CSRFWrap("circle", http.HandlerFunc(circleaction)))
 	LoggedInRouter.HandleFunc("/account", accountpage)
 	LoggedInRouter.HandleFunc("/funzone", showfunzone)
 	LoggedInRouter.HandleFunc("/chpass", dochpass)
+	LoggedInRouter.HandleFunc("/drafts", showdrafts)
+	LoggedInRouter.HandleFunc("/editdraft", editdraftpage)
+	LoggedInRouter.Handle("/draftaction", login.CSRFWrap("draft", http.HandlerFunc(draftaction)))
+	LoggedInRouter.HandleFunc("/exportarchive", exporthandler)
+	LoggedInRouter.Handle("/deleteaccount", login.CSRFWrap("deleteaccount", http.HandlerFunc(deleteaccount)))
+	LoggedInRouter.Handle("/apptoken", login.CSRFWrap("apptoken", http.HandlerFunc(apptokenhandler)))
+	LoggedInRouter.Handle("/revokeauth", login.CSRFWrap("apptoken", http.HandlerFunc(revokehandler)))
+	LoggedInRouter.Handle("/passkeybegin", login.CSRFWrap("passkey", http.HandlerFunc(passkeybegin)))
+	LoggedInRouter.Handle("/passkeyfinish", login.CSRFWrap("passkey", http.HandlerFunc(passkeyfinish)))
+	LoggedInRouter.Handle("/passkeydelete", login.CSRFWrap("passkey", http.HandlerFunc(passkeydelete)))
+	LoggedInRouter.Handle("/recoverycodes", login.CSRFWrap("passkey", http.HandlerFunc(newrecoverycodes)))
+	LoggedInRouter.HandleFunc("/admin", adminpage)
+	LoggedInRouter.Handle("/adminaction", login.CSRFWrap("admin", http.HandlerFunc(adminaction)))
+	LoggedInRouter.Handle("/adminconfig", login.CSRFWrap("admin", http.HandlerFunc(adminconfig)))
 	LoggedInRouter.HandleFunc("/atme", homepage)
 	LoggedInRouter.HandleFunc("/longago", homepage)
 	LoggedInRouter.HandleFunc("/hfcs", hfcspage)
@@ -3362,7 +3743,11 @@ func serve() {
 	LoggedInRouter.Handle("/honk", login.CSRFWrap("honkhonk", http.HandlerFunc(websubmithonk)))
 	LoggedInRouter.Handle("/bonk", login.CSRFWrap("honkhonk", http.HandlerFunc(submitbonk)))
 	LoggedInRouter.Handle("/zonkit", login.CSRFWrap("honkhonk", http.HandlerFunc(zonkit)))
+	LoggedInRouter.Handle("/describe", login.CSRFWrap("honkhonk", http.HandlerFunc(describedonk)))
 	LoggedInRouter.Handle("/savehfcs", login.CSRFWrap("filter", http.HandlerFunc(savehfcs)))
+	LoggedInRouter.HandleFunc("/hfcsexport", exportfilters)
+	LoggedInRouter.Handle("/hfcsimport", login.CSRFWrap("filter", http.HandlerFunc(importfiltershandler)))
+	LoggedInRouter.Handle("/filterlist", login.CSRFWrap("filter", http.HandlerFunc(filterlisthandler)))
 	LoggedInRouter.Handle("/saveuser", login.CSRFWrap("saveuser", http.HandlerFunc(saveuser)))
 	LoggedInRouter.Handle("/ximport", login.CSRFWrap("ximport", http.HandlerFunc(ximport)))
 	LoggedInRouter.HandleFunc("/honkers", showhonkers)
@@ -3374,7 +3759,9 @@ func serve() {
 	LoggedInRouter.HandleFunc("/q", showsearch)
 	LoggedInRouter.HandleFunc("/hydra", webhydra)
 	LoggedInRouter.HandleFunc("/emus", showemus)
+	LoggedInRouter.HandleFunc("/proxy", proxyhandler)
 	LoggedInRouter.Handle("/submithonker", login.CSRFWrap("submithonker", http.HandlerFunc(websubmithonker)))
+	LoggedInRouter.Handle("/dubaction", login.CSRFWrap("submithonker", http.HandlerFunc(dubaction)))
 
 	httpHandler := http.NewServeMux()
 	httpHandler.HandleFunc("/", redirect)